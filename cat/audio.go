@@ -0,0 +1,130 @@
+package cat
+
+import (
+	"cmp"
+	"fmt"
+)
+
+// buildAudio sets up the audio of the output. Audio is stream copied
+// unless some processing is configured.
+func (b *builder) buildAudio() error {
+	a := b.c.EditOptions.Audio
+	if !a.Normalize && a.Music == "" && !b.speedChanged() {
+		if b.in.Mute {
+			return nil
+		}
+		if len(a.Streams) == 0 {
+			b.audioMaps = []string{"0:a?"}
+			return nil
+		}
+		for _, s := range a.Streams {
+			b.audioMaps = append(b.audioMaps, fmt.Sprintf("0:a:%d", s))
+		}
+		return nil
+	}
+	game := ""
+	if !b.in.Mute {
+		var err error
+		game, err = b.gameAudio()
+		if err != nil {
+			return err
+		}
+	}
+	if a.Music == "" {
+		b.audio = game
+		return nil
+	}
+	music := b.music()
+	if game == "" {
+		b.audio = music
+		return nil
+	}
+	if a.Ducking {
+		g, sc := b.label(), b.label()
+		b.node([]string{game}, []Filter{filter("asplit", opt("", 2))}, []string{g, sc})
+		ducked := b.label()
+		b.node([]string{music, sc}, []Filter{
+			filter("sidechaincompress", opt("threshold", 0.05), opt("ratio", 8), opt("attack", 20), opt("release", 400)),
+		}, []string{ducked})
+		game, music = g, ducked
+	}
+	out := b.label()
+	b.node([]string{game, music}, []Filter{
+		filter("amix", opt("inputs", 2), opt("duration", "first"), opt("normalize", 0)),
+	}, []string{out})
+	b.audio = out
+	return nil
+}
+
+// gameAudio mixes the selected streams of the original, all of them when
+// none are selected, and normalizes them. An original without audio is
+// silent.
+func (b *builder) gameAudio() (string, error) {
+	a := b.c.EditOptions.Audio
+	inputs := []string{}
+	for _, s := range a.Streams {
+		inputs = append(inputs, fmt.Sprintf("0:a:%d", s))
+	}
+	if len(inputs) == 0 {
+		info, err := b.prober.probe()
+		if err != nil {
+			return "", err
+		}
+		if info.AudioStreams == 0 {
+			return b.silence(b.duration), nil
+		}
+		for s := range info.AudioStreams {
+			inputs = append(inputs, fmt.Sprintf("0:a:%d", s))
+		}
+	}
+	out := b.label()
+	if len(inputs) > 1 {
+		b.node(inputs, []Filter{filter("amix", opt("inputs", len(inputs)), opt("normalize", 0))}, []string{out})
+	} else {
+		b.node(inputs, []Filter{filter("anull")}, []string{out})
+	}
+	out = b.speedAudio(out)
+	if a.Normalize {
+		in := out
+		out = b.label()
+		b.node([]string{in}, []Filter{filter("loudnorm", opt("I", cmp.Or(a.TargetLufs, -14)), opt("TP", -1.5), opt("LRA", 11))}, []string{out})
+	}
+	return out, nil
+}
+
+// music loops the music track over the clip with fades.
+func (b *builder) music() string {
+	a := b.c.EditOptions.Audio
+	idx := b.addInput(a.Music, "-stream_loop", "-1")
+	filters := []Filter{
+		filter("atrim", opt("duration", b.duration)),
+		filter("volume", opt("", cmp.Or(a.MusicVolume, 0.3))),
+	}
+	if a.MusicFadeIn > 0 {
+		filters = append(filters, filter("afade", opt("t", "in"), opt("st", 0), opt("d", a.MusicFadeIn)))
+	}
+	if a.MusicFadeOut > 0 {
+		filters = append(filters, filter("afade", opt("t", "out"), opt("st", max(b.duration-a.MusicFadeOut, 0)), opt("d", a.MusicFadeOut)))
+	}
+	out := b.label()
+	b.node([]string{fmt.Sprintf("%d:a", idx)}, filters, []string{out})
+	return out
+}
+
+func (b *builder) outputArgs() []string {
+	args := []string{"-map", "[" + b.video + "]"}
+	if b.audio != "" {
+		args = append(args, "-map", "["+b.audio+"]", "-c:a", cmp.Or(b.profile.AudioCodec, "aac"), "-ar", "48000")
+		if b.profile.AudioBitrate != "" {
+			args = append(args, "-b:a", b.profile.AudioBitrate)
+		}
+		return args
+	}
+	if len(b.audioMaps) == 0 {
+		return append(args, "-an")
+	}
+	for _, m := range b.audioMaps {
+		args = append(args, "-map", m)
+	}
+	return append(args, "-c:a", "copy")
+}
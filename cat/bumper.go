@@ -0,0 +1,117 @@
+package cat
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/wirekang/p0418/cfg"
+	"github.com/wirekang/p0418/utils"
+)
+
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".bmp", ".webp"}
+
+// buildBumpers concatenates the intro and outro around the edited clip.
+// Audio is always filtered then, as concat needs an audio pad per segment.
+func (b *builder) buildBumpers() error {
+	if b.c.Intro == nil && b.c.Outro == nil {
+		return nil
+	}
+	if b.audio == "" {
+		if b.in.Mute {
+			b.audio = b.silence(b.duration)
+		} else {
+			game, err := b.gameAudio()
+			if err != nil {
+				return err
+			}
+			b.audio = game
+		}
+		b.audioMaps = nil
+	}
+	v, a := b.label(), b.label()
+	b.node([]string{b.video}, []Filter{filter("setsar", opt("", 1)), filter("format", opt("", "yuv420p"))}, []string{v})
+	b.node([]string{b.audio}, []Filter{audioFormat()}, []string{a})
+	segments := []string{v, a}
+	if b.c.Intro != nil {
+		iv, ia, err := b.bumper(*b.c.Intro)
+		if err != nil {
+			return fmt.Errorf("intro: %w", err)
+		}
+		segments = append([]string{iv, ia}, segments...)
+	}
+	if b.c.Outro != nil {
+		ov, oa, err := b.bumper(*b.c.Outro)
+		if err != nil {
+			return fmt.Errorf("outro: %w", err)
+		}
+		segments = append(segments, ov, oa)
+	}
+	b.video, b.audio = b.label(), b.label()
+	b.node(segments, []Filter{
+		filter("concat", opt("n", len(segments)/2), opt("v", 1), opt("a", 1)),
+	}, []string{b.video, b.audio})
+	return nil
+}
+
+// bumper scales and pads a clip or still image to the output frame.
+// Clips must have an audio track, images are silent.
+func (b *builder) bumper(bp cfg.Bumper) (string, string, error) {
+	image := slices.Contains(imageExtensions, strings.ToLower(path.Ext(bp.File)))
+	if image && bp.Duration <= 0 {
+		return "", "", fmt.Errorf("image %s needs a duration", bp.File)
+	}
+	options := []string{}
+	if image {
+		options = append(options, "-loop", "1")
+	}
+	if bp.Duration > 0 {
+		options = append(options, "-t", fmt.Sprint(bp.Duration))
+	}
+	d, err := BumperDuration(bp)
+	if err != nil {
+		return "", "", err
+	}
+	idx := b.addInput(bp.File, options...)
+	v := b.label()
+	b.node([]string{fmt.Sprintf("%d:v", idx)}, []Filter{
+		filter("scale", opt("w", b.outputW), opt("h", b.outputH), opt("force_original_aspect_ratio", "decrease")),
+		filter("pad", opt("w", b.outputW), opt("h", b.outputH), opt("x", "(ow-iw)/2"), opt("y", "(oh-ih)/2"), opt("color", b.c.EditOptions.PaddingColor)),
+		filter("setsar", opt("", 1)),
+		filter("format", opt("", "yuv420p")),
+	}, []string{v})
+	b.duration += d
+	if image {
+		return v, b.silence(bp.Duration), nil
+	}
+	a := b.label()
+	b.node([]string{fmt.Sprintf("%d:a", idx)}, []Filter{audioFormat()}, []string{a})
+	return v, a, nil
+}
+
+// BumperDuration is the Duration of the bumper, or the length of the clip
+// when it is not set.
+func BumperDuration(bp cfg.Bumper) (float64, error) {
+	if bp.Duration > 0 {
+		return bp.Duration, nil
+	}
+	info, err := utils.Probe(bp.File)
+	if err != nil {
+		return 0, err
+	}
+	return info.Duration, nil
+}
+
+func (b *builder) silence(duration float64) string {
+	out := b.label()
+	b.node(nil, []Filter{
+		filter("anullsrc", opt("r", 48000), opt("cl", "stereo")),
+		filter("atrim", opt("duration", duration)),
+	}, []string{out})
+	return out
+}
+
+func audioFormat() Filter {
+	return filter("aformat", opt("sample_rates", 48000), opt("channel_layouts", "stereo"))
+}
@@ -2,77 +2,159 @@ package cat
 
 import (
 	"fmt"
-	"strings"
+	"slices"
 
 	"github.com/wirekang/p0418/cfg"
 	"github.com/wirekang/p0418/utils"
 )
 
 var ErrUnknownCategory = fmt.Errorf("unknown video category")
+var ErrUnknownEncodeProfile = fmt.Errorf("unknown encode profile")
 
-func GetCategoryBySourceFileName(f string) (cfg.Category, error) {
+func GetCategoryById(id string) (cfg.Category, error) {
 	for _, c := range cfg.Data.Categories {
-		for _, p := range c.OriginalFilePrefixes {
-			if strings.HasPrefix(f, p) {
-				return c, nil
-			}
+		if c.Id == id {
+			return c, nil
 		}
 	}
 	return cfg.Category{}, ErrUnknownCategory
 }
 
-func GetCategoryById(id string) (cfg.Category, error) {
-	for _, c := range cfg.Data.Categories {
-		if c.Id == id {
-			return c, nil
+func GetEncodeProfile(c cfg.Category) (cfg.EncodeProfile, error) {
+	id := c.EncodeProfile
+	if id == "" {
+		id = cfg.DefaultEncodeProfile
+	}
+	for _, p := range cfg.Data.EncodeProfiles {
+		if p.Id == id {
+			return p, nil
 		}
 	}
-	return cfg.Category{}, ErrUnknownCategory
+	return cfg.EncodeProfile{}, fmt.Errorf("%w %s", ErrUnknownEncodeProfile, id)
 }
 
-func makeFilters(c cfg.Category, v any) ([]string, error) {
-	var outputH = c.EditOptions.OutputHeight
-	var outputW = int(float32(outputH) / c.EditOptions.OutputRatio)
-	var padW = max(c.EditOptions.OriginalWidth, outputW)
-	var padH = max(c.EditOptions.OriginalWidth, outputH)
-	var padX = c.EditOptions.PaddingX
-	var padY = c.EditOptions.PaddingY
-	var padColor = c.EditOptions.PaddingColor
-	var cropW = outputW
-	var cropH = outputH
-	var cropX = (padW - outputW) / 2
-	var cropY = 0
-	var fontFile = c.EditOptions.FontFile
-	var fontColor = c.EditOptions.FontColor
-	var fontSize = c.EditOptions.FontSize
-	var textX = cropX + 16
-	var textY = padY + c.EditOptions.OriginalHeight + 8
+// ApplyOverrides returns the category with the overrides of a video
+// applied. A Text override replaces the first overlay when the category
+// uses overlays.
+func ApplyOverrides(c cfg.Category, o cfg.Overrides) cfg.Category {
+	if o.Text != nil {
+		if len(c.Overlays) > 0 {
+			c.Overlays = slices.Clone(c.Overlays)
+			c.Overlays[0].Text = *o.Text
+		} else {
+			c.Text = *o.Text
+		}
+	}
+	if o.Title != nil {
+		c.YoutubeTitle = *o.Title
+	}
+	if o.Description != nil {
+		c.YoutubeDescription = *o.Description
+	}
+	if o.Tags != nil {
+		c.YoutubeTags = o.Tags
+	}
+	if o.Privacy != nil {
+		c.YoutubePrivacy = *o.Privacy
+	}
+	if o.Layout != nil {
+		c.EditOptions.Layout = *o.Layout
+	}
+	if o.PaddingX != nil {
+		c.EditOptions.PaddingX = *o.PaddingX
+	}
+	if o.PaddingY != nil {
+		c.EditOptions.PaddingY = *o.PaddingY
+	}
+	if o.EncodeProfile != nil {
+		c.EncodeProfile = *o.EncodeProfile
+	}
+	return c
+}
 
-	text, err := utils.TemplateString(c.Text, v)
+// RenderTemplate executes a template of the category, naming the
+// category in errors like config validation does.
+func RenderTemplate(c cfg.Category, t string, data any) (string, error) {
+	s, err := utils.TemplateString(t, data)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("category %s: template: %w", c.Id, err)
 	}
-	r := []string{}
-	r = append(r, fmt.Sprintf("pad=%d:%d:%d:%d:%s", padW, padH, padX, padY, padColor))
-	r = append(r, fmt.Sprintf("drawtext=fontfile='%s':text='%s':fontcolor='%s':fontsize=%d:x=%d:y=%d:line_spacing=-10", fontFile, text, fontColor, fontSize, textX, textY))
-	r = append(r, fmt.Sprintf("crop=%d:%d:%d:%d", cropW, cropH, cropX, cropY))
-	return r, nil
+	return s, nil
 }
 
-func FfmpegArgs(c cfg.Category, v any, overrideRange *cfg.Range) (args []string, err error) {
+// Input is the source clip a category is applied to.
+type Input struct {
+	File  string
+	Range *cfg.Range
+	// Subtitles is a subtitle file already shifted to the clip start.
+	Subtitles string
+	Mute      bool
+	// Preview renders at reduced resolution with a fast preset.
+	Preview bool
+	// Data is passed to the category templates.
+	Data any
+}
+
+func FfmpegArgs(c cfg.Category, in Input) (args []string, err error) {
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("making ffmpeg args from category: %w", err)
 		}
 	}()
-	filters, err := makeFilters(c, v)
+	p, err := GetEncodeProfile(c)
 	if err != nil {
 		return nil, err
 	}
-	r := c.DefaultRange
-	if overrideRange != nil {
-		r = *overrideRange
+	if in.Preview {
+		p = previewProfile(p)
 	}
-	args = []string{"-ss", fmt.Sprintf("00:00:%d", r.Start), "-to", fmt.Sprintf("00:00:%d", r.End), "-vf", strings.Join(filters, ",")}
+	b := newBuilder(c, in)
+	b.profile = p
+	err = b.build()
+	if err != nil {
+		return nil, err
+	}
+	if in.Preview {
+		b.chain(filter("scale", opt("w", "trunc(iw/6)*2"), opt("h", -2)))
+	}
+	args = b.inputArgs()
+	args = append(args, "-filter_complex", b.graph.String())
+	args = append(args, b.outputArgs()...)
+	args = append(args, encodeArgs(p)...)
 	return args, nil
 }
+
+func previewProfile(p cfg.EncodeProfile) cfg.EncodeProfile {
+	p.Bitrate = ""
+	p.Crf = 30
+	p.Preset = "ultrafast"
+	p.MaxFrameRate = 30
+	p.FastStart = false
+	p.ExtraArgs = nil
+	return p
+}
+
+func encodeArgs(p cfg.EncodeProfile) []string {
+	args := []string{"-c:v", p.VideoCodec}
+	if p.Bitrate != "" {
+		args = append(args, "-b:v", p.Bitrate)
+	} else {
+		args = append(args, "-crf", fmt.Sprint(p.Crf))
+	}
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	if p.Profile != "" {
+		args = append(args, "-profile:v", p.Profile)
+	}
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt", p.PixelFormat)
+	}
+	if p.MaxFrameRate > 0 {
+		args = append(args, "-fpsmax", fmt.Sprint(p.MaxFrameRate))
+	}
+	if p.FastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, p.ExtraArgs...)
+}
@@ -0,0 +1,103 @@
+package cat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option is a single key=value filter option. Options with an empty Key
+// are positional.
+type Option struct {
+	Key   string
+	Value string
+}
+
+func opt(key string, value any) Option {
+	return Option{Key: key, Value: fmt.Sprint(value)}
+}
+
+type Filter struct {
+	Name    string
+	Options []Option
+}
+
+func filter(name string, options ...Option) Filter {
+	return Filter{Name: name, Options: options}
+}
+
+// String serializes the filter with every option value escaped, and the
+// whole argument list escaped again for the filtergraph level.
+// https://ffmpeg.org/ffmpeg-filters.html#Notes-on-filtergraph-escaping
+func (f Filter) String() string {
+	if len(f.Options) == 0 {
+		return f.Name
+	}
+	opts := make([]string, len(f.Options))
+	for i, o := range f.Options {
+		if o.Key == "" {
+			opts[i] = escapeValue(o.Value)
+			continue
+		}
+		opts[i] = o.Key + "=" + escapeValue(o.Value)
+	}
+	return f.Name + "=" + escape(strings.Join(opts, ":"), "\\'[],;")
+}
+
+func escape(s string, special string) string {
+	b := strings.Builder{}
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapeValue escapes an option value for the filter option parser, which
+// also trims unescaped leading and trailing whitespace.
+func escapeValue(s string) string {
+	e := escape(s, "\\':")
+	trimmed := strings.TrimLeft(e, " \t\n\r")
+	lead := e[:len(e)-len(trimmed)]
+	body := strings.TrimRight(trimmed, " \t\n\r")
+	trail := trimmed[len(body):]
+	return escape(lead, lead) + body + escape(trail, trail)
+}
+
+// Node is a chain of filters reading from labeled input pads and writing
+// to labeled output pads.
+type Node struct {
+	Inputs  []string
+	Filters []Filter
+	Outputs []string
+}
+
+func (n Node) String() string {
+	b := strings.Builder{}
+	for _, l := range n.Inputs {
+		b.WriteString("[" + l + "]")
+	}
+	filters := make([]string, len(n.Filters))
+	for i, f := range n.Filters {
+		filters[i] = f.String()
+	}
+	b.WriteString(strings.Join(filters, ","))
+	for _, l := range n.Outputs {
+		b.WriteString("[" + l + "]")
+	}
+	return b.String()
+}
+
+// Graph serializes to the argument of -filter_complex.
+type Graph struct {
+	Nodes []Node
+}
+
+func (g Graph) String() string {
+	nodes := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		nodes[i] = n.String()
+	}
+	return strings.Join(nodes, ";")
+}
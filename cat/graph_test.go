@@ -0,0 +1,36 @@
+package cat
+
+import "testing"
+
+func TestFilterString(t *testing.T) {
+	tests := []struct {
+		name string
+		f    Filter
+		want string
+	}{
+		{"plain", filter("drawtext", opt("text", escapeText("hello"))), `drawtext=text=hello`},
+		{"colon", filter("drawtext", opt("text", escapeText("a:b"))), `drawtext=text=a\\:b`},
+		{"quote", filter("drawtext", opt("text", escapeText("it's"))), `drawtext=text=it\\\'s`},
+		{"backslash", filter("drawtext", opt("text", escapeText(`a\b`))), `drawtext=text=a\\\\\\\\b`},
+		{"percent", filter("drawtext", opt("text", escapeText("100%"))), `drawtext=text=100\\\\%`},
+		{"comma", filter("drawtext", opt("text", escapeText("a,b"))), `drawtext=text=a\,b`},
+		{"semicolon", filter("drawtext", opt("text", escapeText("a;b"))), `drawtext=text=a\;b`},
+		{"brackets", filter("drawtext", opt("text", escapeText("[x]"))), `drawtext=text=\[x\]`},
+		{"spaces", filter("drawtext", opt("text", escapeText("  a b  "))), `drawtext=text=\\ \\ a b\\ \\ `},
+		{"hangul", filter("drawtext", opt("text", escapeText("펜타킬: 승리"))), `drawtext=text=펜타킬\\: 승리`},
+		{"cjk", filter("drawtext", opt("text", escapeText("五杀，胜利'"))), `drawtext=text=五杀，胜利\\\'`},
+		{"font path", filter("drawtext", opt("fontfile", fontFile("C:/Windows/Fonts/arial.ttf"))), `drawtext=fontfile=C\\:/Windows/Fonts/arial.ttf`},
+		{"legacy font path", filter("drawtext", opt("fontfile", fontFile(`C\:/Windows/Fonts/arial.ttf`))), `drawtext=fontfile=C\\:/Windows/Fonts/arial.ttf`},
+		{"injection", filter("drawtext", opt("text", escapeText("x:fontcolor=red")), opt("fontsize", 48)), `drawtext=text=x\\:fontcolor=red:fontsize=48`},
+		{"mixed", filter("drawtext", opt("text", escapeText(`it's 100%: a,b [x]; c\d`))), `drawtext=text=it\\\'s 100\\\\%\\: a\,b \[x\]\; c\\\\\\\\d`},
+		{"no options", filter("null"), `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.f.String()
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
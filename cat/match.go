@@ -0,0 +1,195 @@
+package cat
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wirekang/p0418/cfg"
+	"github.com/wirekang/p0418/utils"
+)
+
+var regexps = map[string]*regexp.Regexp{}
+
+func compile(expr string) (*regexp.Regexp, error) {
+	r, ok := regexps[expr]
+	if ok {
+		return r, nil
+	}
+	r, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexps[expr] = r
+	return r, nil
+}
+
+// prober probes the file once, only when a rule needs it.
+type prober struct {
+	file  string
+	info  *utils.ProbeInfo
+	err   error
+	tried bool
+}
+
+func (p *prober) probe() (utils.ProbeInfo, error) {
+	if !p.tried {
+		p.tried = true
+		info, err := utils.Probe(p.file)
+		p.info, p.err = &info, err
+	}
+	return *p.info, p.err
+}
+
+// rules returns the rules of the category including the legacy prefixes.
+func rules(c cfg.Category) []cfg.MatchRule {
+	rs := slices.Clone(c.Rules)
+	for _, p := range c.OriginalFilePrefixes {
+		rs = append(rs, cfg.MatchRule{Prefix: p})
+	}
+	return rs
+}
+
+func needsProbe(r cfg.MatchRule) bool {
+	return r.MinWidth > 0 || r.MaxWidth > 0 || r.MinHeight > 0 || r.MaxHeight > 0 || r.MinDuration > 0 || r.MaxDuration > 0
+}
+
+// Match holds the named groups captured by a regex rule, and the groups
+// parsed as dates by its DateLayouts.
+type Match struct {
+	Vars  map[string]string
+	Dates map[string]time.Time
+}
+
+func matchRule(r cfg.MatchRule, name string, p *prober, m *Match) (bool, error) {
+	if r.Prefix != "" && !strings.HasPrefix(name, r.Prefix) {
+		return false, nil
+	}
+	if r.Glob != "" {
+		ok, err := path.Match(r.Glob, name)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if r.Regex != "" {
+		re, err := compile(r.Regex)
+		if err != nil {
+			return false, err
+		}
+		sub := re.FindStringSubmatch(name)
+		if sub == nil {
+			return false, nil
+		}
+		m.Vars = map[string]string{}
+		for i, n := range re.SubexpNames() {
+			if n != "" {
+				m.Vars[n] = sub[i]
+			}
+		}
+	}
+	if len(r.Extensions) > 0 && !slices.ContainsFunc(r.Extensions, func(e string) bool { return strings.EqualFold(e, path.Ext(name)) }) {
+		return false, nil
+	}
+	if !needsProbe(r) {
+		return true, nil
+	}
+	info, err := p.probe()
+	if err != nil {
+		// files ffprobe can't read end up unsorted
+		return false, nil
+	}
+	ok := (r.MinWidth == 0 || info.Width >= r.MinWidth) &&
+		(r.MaxWidth == 0 || info.Width <= r.MaxWidth) &&
+		(r.MinHeight == 0 || info.Height >= r.MinHeight) &&
+		(r.MaxHeight == 0 || info.Height <= r.MaxHeight) &&
+		(r.MinDuration == 0 || info.Duration >= r.MinDuration) &&
+		(r.MaxDuration == 0 || info.Duration <= r.MaxDuration)
+	return ok, nil
+}
+
+// GetCategoryBySourceFile returns the category with the highest Priority
+// having a rule matching the file. Categories of the same priority are
+// tried in config order.
+func GetCategoryBySourceFile(file string) (cfg.Category, Match, error) {
+	return GetCategoryByName(path.Base(file), file)
+}
+
+// GetCategoryByName matches rules against name, probing file, which is
+// the original of an ingested video whose source may be gone.
+func GetCategoryByName(name string, file string) (cfg.Category, Match, error) {
+	cs := slices.Clone(cfg.Data.Categories)
+	slices.SortStableFunc(cs, func(a, b cfg.Category) int { return cmp.Compare(b.Priority, a.Priority) })
+	p := &prober{file: file}
+	skipped := []error{}
+	for _, c := range cs {
+		m, ok, err := matchCategory(c, name, p, &skipped)
+		if err != nil {
+			return cfg.Category{}, m, err
+		}
+		if ok {
+			return c, m, nil
+		}
+	}
+	return cfg.Category{}, Match{}, unknownCategory(skipped)
+}
+
+// MatchCategory returns the captures of the first rule of c matching
+// name. It returns ErrUnknownCategory when none does.
+func MatchCategory(c cfg.Category, name string, file string) (Match, error) {
+	skipped := []error{}
+	m, ok, err := matchCategory(c, name, &prober{file: file}, &skipped)
+	if err != nil || ok {
+		return m, err
+	}
+	return Match{}, unknownCategory(skipped)
+}
+
+// unknownCategory wraps ErrUnknownCategory with the reasons rules were
+// skipped, so the caller can tell an odd name from an unknown one.
+func unknownCategory(skipped []error) error {
+	if len(skipped) == 0 {
+		return ErrUnknownCategory
+	}
+	return fmt.Errorf("%w: %w", ErrUnknownCategory, errors.Join(skipped...))
+}
+
+// matchCategory returns the captures of the first rule of c matching name.
+// A rule whose dates don't parse doesn't match and is added to skipped.
+func matchCategory(c cfg.Category, name string, p *prober, skipped *[]error) (Match, bool, error) {
+	for _, r := range rules(c) {
+		m := Match{}
+		ok, err := matchRule(r, name, p, &m)
+		if err != nil {
+			return Match{}, false, err
+		}
+		if !ok {
+			continue
+		}
+		err = parseDates(r, &m)
+		if err != nil {
+			*skipped = append(*skipped, fmt.Errorf("category %s: %w", c.Id, err))
+			continue
+		}
+		return m, true, nil
+	}
+	return Match{}, false, nil
+}
+
+func parseDates(r cfg.MatchRule, m *Match) error {
+	for group, layout := range r.DateLayouts {
+		t, err := time.ParseInLocation(layout, m.Vars[group], time.Local)
+		if err != nil {
+			return fmt.Errorf("date %s: %w", group, err)
+		}
+		if m.Dates == nil {
+			m.Dates = map[string]time.Time{}
+		}
+		m.Dates[group] = t
+	}
+	return nil
+}
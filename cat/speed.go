@@ -0,0 +1,146 @@
+package cat
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/wirekang/p0418/cfg"
+)
+
+// ShortsMaxDuration is the longest video YouTube accepts as a Short.
+const ShortsMaxDuration = 60.0
+
+type segment struct {
+	start float64
+	end   float64
+	speed float64
+}
+
+// segments splits the clip at the speed ramp keyframes. Times are seconds
+// from the range start.
+func (b *builder) segments() ([]segment, error) {
+	return segments(b.rng)
+}
+
+func segments(rng cfg.Range) ([]segment, error) {
+	length := float64(rng.End - rng.Start)
+	ramps := slices.Clone(rng.Ramps)
+	slices.SortFunc(ramps, func(a, b cfg.SpeedRamp) int { return cmp.Compare(a.At, b.At) })
+	ss := []segment{{start: 0, end: length, speed: cmp.Or(rng.Speed, 1)}}
+	for _, r := range ramps {
+		if r.At <= 0 || r.At >= length {
+			return nil, fmt.Errorf("speed ramp at %gs is outside of the range", r.At)
+		}
+		last := &ss[len(ss)-1]
+		last.end = r.At
+		ss = append(ss, segment{start: r.At, end: length, speed: r.Speed})
+	}
+	for _, s := range ss {
+		if s.speed <= 0 {
+			return nil, fmt.Errorf("wrong speed %g", s.speed)
+		}
+	}
+	return ss, nil
+}
+
+// OutputTime maps seconds from the range start of the original to seconds
+// of the retimed output, so timed overlays and subtitles stay in sync.
+func OutputTime(rng cfg.Range, t float64) float64 {
+	ss, err := segments(rng)
+	if err != nil || t <= 0 {
+		return t
+	}
+	out := 0.0
+	for i, s := range ss {
+		if t <= s.end || i == len(ss)-1 {
+			return out + (t-s.start)/s.speed
+		}
+		out += (s.end - s.start) / s.speed
+	}
+	return out
+}
+
+func (b *builder) speedChanged() bool {
+	return (b.rng.Speed != 0 && b.rng.Speed != 1) || len(b.rng.Ramps) > 0
+}
+
+// buildSpeed retimes the video before any stage and recalculates the duration.
+func (b *builder) buildSpeed() error {
+	if !b.speedChanged() {
+		return nil
+	}
+	ss, err := b.segments()
+	if err != nil {
+		return err
+	}
+	b.duration = 0
+	for _, s := range ss {
+		b.duration += (s.end - s.start) / s.speed
+	}
+	if len(ss) == 1 {
+		b.chain(filter("setpts", opt("", fmt.Sprintf("PTS/%g", ss[0].speed))))
+		return nil
+	}
+	parts := b.split(b.video, "split", len(ss))
+	for i, s := range ss {
+		out := b.label()
+		b.node([]string{parts[i]}, []Filter{
+			filter("trim", opt("start", s.start), opt("end", s.end)),
+			filter("setpts", opt("", fmt.Sprintf("(PTS-STARTPTS)/%g", s.speed))),
+		}, []string{out})
+		parts[i] = out
+	}
+	b.video = b.label()
+	b.node(parts, []Filter{filter("concat", opt("n", len(ss)), opt("v", 1), opt("a", 0))}, []string{b.video})
+	return nil
+}
+
+// speedAudio retimes an audio pad like buildSpeed does the video.
+func (b *builder) speedAudio(in string) string {
+	if !b.speedChanged() {
+		return in
+	}
+	ss, _ := b.segments()
+	if len(ss) == 1 {
+		out := b.label()
+		b.node([]string{in}, atempo(ss[0].speed), []string{out})
+		return out
+	}
+	parts := b.split(in, "asplit", len(ss))
+	for i, s := range ss {
+		out := b.label()
+		filters := []Filter{
+			filter("atrim", opt("start", s.start), opt("end", s.end)),
+			filter("asetpts", opt("", "PTS-STARTPTS")),
+		}
+		b.node([]string{parts[i]}, append(filters, atempo(s.speed)...), []string{out})
+		parts[i] = out
+	}
+	out := b.label()
+	b.node(parts, []Filter{filter("concat", opt("n", len(ss)), opt("v", 0), opt("a", 1))}, []string{out})
+	return out
+}
+
+func (b *builder) split(in string, name string, n int) []string {
+	outs := make([]string, n)
+	for i := range outs {
+		outs[i] = b.label()
+	}
+	b.node([]string{in}, []Filter{filter(name, opt("", n))}, slices.Clone(outs))
+	return outs
+}
+
+// atempo chains filters as a single atempo only accepts 0.5 to 2.
+func atempo(speed float64) []Filter {
+	filters := []Filter{}
+	for speed > 2 {
+		filters = append(filters, filter("atempo", opt("", 2)))
+		speed /= 2
+	}
+	for speed < 0.5 {
+		filters = append(filters, filter("atempo", opt("", 0.5)))
+		speed /= 0.5
+	}
+	return append(filters, filter("atempo", opt("", speed)))
+}
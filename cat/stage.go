@@ -0,0 +1,477 @@
+package cat
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/wirekang/p0418/cfg"
+)
+
+// DefaultStages is the pipeline of categories without Stages.
+var DefaultStages = []cfg.Stage{{Name: "layout"}, {Name: "text"}}
+
+type stageFunc func(b *builder, params map[string]string) error
+
+var stages = map[string]stageFunc{
+	"layout":    layoutStage,
+	"pad":       padStage,
+	"crop":      cropStage,
+	"blur":      blurStage,
+	"focus":     focusStage,
+	"text":      textStage,
+	"images":    imagesStage,
+	"subtitles": subtitlesStage,
+}
+
+type builder struct {
+	c      cfg.Category
+	in     Input
+	graph  Graph
+	labels int
+	// video is the label of the current video pad.
+	video string
+	// audio is the label of the filtered audio pad. When empty, audioMaps
+	// are stream copied.
+	audio     string
+	audioMaps []string
+	// originX and originY are the top left corner of the output frame in current coordinates.
+	originX int
+	originY int
+	// contentBottom is the y right below the original picture in current coordinates.
+	contentBottom int
+	outputW       int
+	outputH       int
+	rng           cfg.Range
+	profile       cfg.EncodeProfile
+	// duration of the output in seconds.
+	duration float64
+	// inputs are added after the original, which is input 0.
+	inputs []input
+	// prober probes the original once when needed.
+	prober *prober
+}
+
+type input struct {
+	options []string
+	file    string
+}
+
+func newBuilder(c cfg.Category, in Input) *builder {
+	outputH := c.EditOptions.OutputHeight
+	r := c.DefaultRange
+	if in.Range != nil {
+		r = *in.Range
+	}
+	return &builder{
+		c:             c,
+		in:            in,
+		video:         "0:v",
+		contentBottom: c.EditOptions.OriginalHeight,
+		outputW:       int(float32(outputH) / c.EditOptions.OutputRatio),
+		outputH:       outputH,
+		rng:           r,
+		duration:      float64(r.End - r.Start),
+		prober:        &prober{file: in.File},
+	}
+}
+
+// addInput returns the index of a new input file.
+func (b *builder) addInput(file string, options ...string) int {
+	b.inputs = append(b.inputs, input{options: options, file: file})
+	return len(b.inputs)
+}
+
+func (b *builder) inputArgs() []string {
+	args := []string{"-ss", strconv.Itoa(b.rng.Start), "-to", strconv.Itoa(b.rng.End), "-i", b.in.File}
+	for _, i := range b.inputs {
+		args = append(args, i.options...)
+		args = append(args, "-i", i.file)
+	}
+	return args
+}
+
+func (b *builder) label() string {
+	b.labels += 1
+	return fmt.Sprintf("v%d", b.labels)
+}
+
+// chain appends filters to the current video pad.
+func (b *builder) chain(filters ...Filter) {
+	out := b.label()
+	b.node([]string{b.video}, filters, []string{out})
+	b.video = out
+}
+
+func (b *builder) node(inputs []string, filters []Filter, outputs []string) {
+	b.graph.Nodes = append(b.graph.Nodes, Node{Inputs: inputs, Filters: filters, Outputs: outputs})
+}
+
+func (b *builder) build() error {
+	ss := b.c.Stages
+	if len(ss) == 0 {
+		ss = DefaultStages
+	}
+	// subtitles and images are drawn last unless the pipeline places them
+	if b.in.Subtitles != "" && !hasStage(ss, "subtitles") {
+		ss = append(slices.Clone(ss), cfg.Stage{Name: "subtitles"})
+	}
+	if len(b.c.EditOptions.Images) > 0 && !hasStage(ss, "images") {
+		ss = append(slices.Clone(ss), cfg.Stage{Name: "images"})
+	}
+	err := b.buildSpeed()
+	if err != nil {
+		return err
+	}
+	for _, s := range ss {
+		f, ok := stages[s.Name]
+		if !ok {
+			return fmt.Errorf("unknown stage %s", s.Name)
+		}
+		err := f(b, s.Params)
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", s.Name, err)
+		}
+	}
+	if len(b.graph.Nodes) == 0 {
+		b.chain(filter("null"))
+	}
+	err = b.buildAudio()
+	if err != nil {
+		return err
+	}
+	err = b.buildBumpers()
+	if err != nil {
+		return err
+	}
+	if b.duration > ShortsMaxDuration {
+		return fmt.Errorf("output is %gs, longer than the Shorts limit of %gs", b.duration, ShortsMaxDuration)
+	}
+	return nil
+}
+
+func hasStage(ss []cfg.Stage, name string) bool {
+	return slices.ContainsFunc(ss, func(s cfg.Stage) bool { return s.Name == name })
+}
+
+// withParams overrides options by stage params. Unknown params are
+// appended in key order.
+func withParams(opts []Option, params map[string]string) []Option {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		i := slices.IndexFunc(opts, func(o Option) bool { return o.Key == k })
+		if i == -1 {
+			opts = append(opts, opt(k, params[k]))
+			continue
+		}
+		opts[i].Value = params[k]
+	}
+	return opts
+}
+
+func (b *builder) padSize() (int, int) {
+	e := b.c.EditOptions
+	return max(e.OriginalWidth, b.outputW), max(e.OriginalWidth, b.outputH)
+}
+
+func padStage(b *builder, params map[string]string) error {
+	e := b.c.EditOptions
+	padW, padH := b.padSize()
+	b.chain(filter("pad", withParams([]Option{
+		opt("w", padW),
+		opt("h", padH),
+		opt("x", e.PaddingX),
+		opt("y", e.PaddingY),
+		opt("color", e.PaddingColor),
+	}, params)...))
+	b.originX += (padW - b.outputW) / 2
+	b.contentBottom += e.PaddingY
+	return nil
+}
+
+func cropStage(b *builder, params map[string]string) error {
+	padW, _ := b.padSize()
+	cropX := (padW - b.outputW) / 2
+	cropY := 0
+	b.chain(filter("crop", withParams([]Option{
+		opt("w", b.outputW),
+		opt("h", b.outputH),
+		opt("x", cropX),
+		opt("y", cropY),
+	}, params)...))
+	b.originX -= cropX
+	b.originY -= cropY
+	b.contentBottom -= cropY
+	return nil
+}
+
+func layoutStage(b *builder, params map[string]string) error {
+	switch b.c.EditOptions.Layout {
+	case "", "pad":
+		err := padStage(b, params)
+		if err != nil {
+			return err
+		}
+		return cropStage(b, nil)
+	case "blur":
+		return blurStage(b, params)
+	case "focus":
+		return focusStage(b, params)
+	}
+	return fmt.Errorf("unknown layout %s", b.c.EditOptions.Layout)
+}
+
+// scaledHeight is the even height of the original picture scaled to the output width.
+func (b *builder) scaledHeight(w int, h int) int {
+	sh := h * b.outputW / w
+	return sh - sh%2
+}
+
+// blurStage fills the frame with a scaled, blurred copy of the clip behind
+// the sharp original.
+func blurStage(b *builder, params map[string]string) error {
+	e := b.c.EditOptions
+	bg, fg := b.label(), b.label()
+	b.node([]string{b.video}, []Filter{filter("split", opt("", 2))}, []string{bg, fg})
+	bgOut, fgOut := b.label(), b.label()
+	b.node([]string{bg}, []Filter{
+		filter("scale", opt("w", b.outputW), opt("h", b.outputH), opt("force_original_aspect_ratio", "increase")),
+		filter("crop", opt("w", b.outputW), opt("h", b.outputH)),
+		filter("gblur", withParams([]Option{opt("sigma", cmp.Or(e.BlurSigma, 20))}, params)...),
+	}, []string{bgOut})
+	fgH := b.scaledHeight(e.OriginalWidth, e.OriginalHeight)
+	b.node([]string{fg}, []Filter{filter("scale", opt("w", b.outputW), opt("h", fgH))}, []string{fgOut})
+	out := b.label()
+	b.node([]string{bgOut, fgOut}, []Filter{filter("overlay", opt("x", "(W-w)/2"), opt("y", "(H-h)/2"))}, []string{out})
+	b.video = out
+	b.originX, b.originY = 0, 0
+	b.contentBottom = (b.outputH + fgH) / 2
+	return nil
+}
+
+// focusStage stacks a crop of the focus region above the full frame.
+func focusStage(b *builder, params map[string]string) error {
+	e := b.c.EditOptions
+	f := e.Focus
+	if f.Width <= 0 || f.Height <= 0 {
+		return fmt.Errorf("focus region is empty")
+	}
+	focusH := f.OutputHeight
+	if focusH <= 0 {
+		focusH = b.scaledHeight(f.Width, f.Height)
+	}
+	fullH := b.scaledHeight(e.OriginalWidth, e.OriginalHeight)
+	if focusH+fullH > b.outputH {
+		return fmt.Errorf("focus %d and frame %d are taller than output %d", focusH, fullH, b.outputH)
+	}
+	top, bottom := b.label(), b.label()
+	b.node([]string{b.video}, []Filter{filter("split", opt("", 2))}, []string{top, bottom})
+	topOut, bottomOut := b.label(), b.label()
+	b.node([]string{top}, []Filter{
+		filter("crop", opt("w", f.Width), opt("h", f.Height), opt("x", f.X), opt("y", f.Y)),
+		filter("scale", opt("w", b.outputW), opt("h", focusH)),
+	}, []string{topOut})
+	b.node([]string{bottom}, []Filter{filter("scale", opt("w", b.outputW), opt("h", fullH))}, []string{bottomOut})
+	y := (b.outputH - focusH - fullH) / 2
+	out := b.label()
+	b.node([]string{topOut, bottomOut}, []Filter{
+		filter("vstack", opt("inputs", 2)),
+		filter("pad", withParams([]Option{
+			opt("w", b.outputW),
+			opt("h", b.outputH),
+			opt("x", 0),
+			opt("y", y),
+			opt("color", e.PaddingColor),
+		}, params)...),
+	}, []string{out})
+	b.video = out
+	b.originX, b.originY = 0, 0
+	b.contentBottom = y + focusH + fullH
+	return nil
+}
+
+func textStage(b *builder, params map[string]string) error {
+	if len(b.c.Overlays) > 0 {
+		return overlaysStage(b, params)
+	}
+	e := b.c.EditOptions
+	text, err := RenderTemplate(b.c, b.c.Text, b.in.Data)
+	if err != nil {
+		return err
+	}
+	b.chain(filter("drawtext", withParams([]Option{
+		opt("fontfile", fontFile(e.FontFile)),
+		opt("text", escapeText(text)),
+		opt("fontcolor", e.FontColor),
+		opt("fontsize", e.FontSize),
+		opt("x", b.originX+16),
+		opt("y", b.contentBottom+8),
+		opt("line_spacing", -10),
+	}, params)...))
+	return nil
+}
+
+func overlaysStage(b *builder, params map[string]string) error {
+	filters := make([]Filter, 0, len(b.c.Overlays))
+	for i, o := range b.c.Overlays {
+		f, err := b.overlayFilter(o)
+		if err != nil {
+			return fmt.Errorf("overlay %d: %w", i, err)
+		}
+		f.Options = withParams(f.Options, params)
+		filters = append(filters, f)
+	}
+	b.chain(filters...)
+	return nil
+}
+
+func (b *builder) overlayFilter(o cfg.Overlay) (Filter, error) {
+	e := b.c.EditOptions
+	text, err := RenderTemplate(b.c, o.Text, b.in.Data)
+	if err != nil {
+		return Filter{}, err
+	}
+	x, y, err := b.anchor(o.Anchor, o.Align, o.MarginX, o.MarginY, "text_w", "text_h")
+	if err != nil {
+		return Filter{}, err
+	}
+	opts := []Option{
+		opt("fontfile", fontFile(cmp.Or(o.FontFile, e.FontFile))),
+		opt("text", escapeText(text)),
+		opt("fontcolor", cmp.Or(o.FontColor, e.FontColor)),
+		opt("fontsize", cmp.Or(o.FontSize, e.FontSize)),
+		opt("x", x),
+		opt("y", y),
+	}
+	if o.BorderWidth > 0 {
+		opts = append(opts, opt("borderw", o.BorderWidth), opt("bordercolor", cmp.Or(o.BorderColor, "black")))
+	}
+	if o.ShadowX != 0 || o.ShadowY != 0 {
+		opts = append(opts, opt("shadowx", o.ShadowX), opt("shadowy", o.ShadowY), opt("shadowcolor", cmp.Or(o.ShadowColor, "black")))
+	}
+	if o.Box {
+		opts = append(opts, opt("box", 1), opt("boxcolor", cmp.Or(o.BoxColor, "black@0.5")), opt("boxborderw", o.BoxBorderWidth))
+	}
+	if enable := enableExpr(b.outputTime(o.Start), b.outputTime(o.End)); enable != "" {
+		opts = append(opts, opt("enable", enable))
+	}
+	return filter("drawtext", opts...), nil
+}
+
+func subtitlesStage(b *builder, params map[string]string) error {
+	if b.in.Subtitles == "" {
+		return nil
+	}
+	opts := []Option{opt("filename", b.in.Subtitles)}
+	if b.c.EditOptions.SubtitleStyle != "" {
+		opts = append(opts, opt("force_style", b.c.EditOptions.SubtitleStyle))
+	}
+	b.chain(filter("subtitles", withParams(opts, params)...))
+	return nil
+}
+
+func imagesStage(b *builder, params map[string]string) error {
+	for i, img := range b.c.EditOptions.Images {
+		err := b.image(img, params)
+		if err != nil {
+			return fmt.Errorf("image %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (b *builder) image(img cfg.ImageOverlay, params map[string]string) error {
+	idx := b.addInput(img.File, "-loop", "1")
+	filters := []Filter{filter("format", opt("", "rgba"))}
+	if img.Width > 0 {
+		filters = append(filters, filter("scale", opt("w", img.Width), opt("h", -1)))
+	}
+	if img.Opacity > 0 && img.Opacity < 1 {
+		filters = append(filters, filter("colorchannelmixer", opt("aa", img.Opacity)))
+	}
+	if img.FadeIn > 0 {
+		filters = append(filters, filter("fade", opt("t", "in"), opt("st", 0), opt("d", img.FadeIn), opt("alpha", 1)))
+	}
+	if img.FadeOut > 0 {
+		filters = append(filters, filter("fade", opt("t", "out"), opt("st", max(b.duration-img.FadeOut, 0)), opt("d", img.FadeOut), opt("alpha", 1)))
+	}
+	in := b.label()
+	b.node([]string{fmt.Sprintf("%d:v", idx)}, filters, []string{in})
+	x, y, err := b.anchor(img.Anchor, img.Align, img.MarginX, img.MarginY, "w", "h")
+	if err != nil {
+		return err
+	}
+	out := b.label()
+	b.node([]string{b.video, in}, []Filter{
+		filter("overlay", withParams([]Option{opt("x", x), opt("y", y), opt("shortest", 1)}, params)...),
+	}, []string{out})
+	b.video = out
+	return nil
+}
+
+// anchor returns x and y expressions placing an element of size w by h
+// inside the output frame.
+func (b *builder) anchor(anchor string, align string, marginX int, marginY int, w string, h string) (x string, y string, err error) {
+	switch align {
+	case "", "left":
+		x = fmt.Sprint(b.originX + marginX)
+	case "center":
+		x = fmt.Sprintf("%d+(%d-%s)/2", b.originX+marginX, b.outputW, w)
+	case "right":
+		x = fmt.Sprintf("%d-%s", b.originX+b.outputW-marginX, w)
+	default:
+		return "", "", fmt.Errorf("unknown align %s", align)
+	}
+	switch anchor {
+	case "", "top":
+		y = fmt.Sprint(b.originY + marginY)
+	case "center":
+		y = fmt.Sprintf("%d+(%d-%s)/2", b.originY+marginY, b.outputH, h)
+	case "bottom":
+		y = fmt.Sprintf("%d-%s", b.originY+b.outputH-marginY, h)
+	default:
+		return "", "", fmt.Errorf("unknown anchor %s", anchor)
+	}
+	return x, y, nil
+}
+
+// outputTime maps an overlay time, seconds into the original clip, onto
+// the output after buildSpeed retimed it.
+func (b *builder) outputTime(t *float64) *float64 {
+	if t == nil {
+		return nil
+	}
+	o := OutputTime(b.rng, *t)
+	return &o
+}
+
+// enableExpr limits a filter to the seconds between start and end.
+func enableExpr(start *float64, end *float64) string {
+	switch {
+	case start != nil && end != nil:
+		return fmt.Sprintf("between(t,%g,%g)", *start, *end)
+	case start != nil:
+		return fmt.Sprintf("gte(t,%g)", *start)
+	case end != nil:
+		return fmt.Sprintf("lte(t,%g)", *end)
+	}
+	return ""
+}
+
+// escapeText escapes the drawtext expansion characters. Filter option
+// escaping is done when the graph is serialized.
+func escapeText(s string) string {
+	return escape(s, "\\%")
+}
+
+// fontFile undoes the manual colon escaping older configs needed for
+// Windows drive letters, as escaping is now done on serialization.
+func fontFile(s string) string {
+	return strings.ReplaceAll(s, "\\:", ":")
+}
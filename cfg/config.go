@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
+	"regexp"
+	"time"
 
 	"github.com/wirekang/p0418/utils"
 )
 
 var FileName = "config.json"
 
+var QuotaFileName = "quota.json"
+
 const CategoryLol = "lol"
 
 type Config struct {
@@ -19,41 +24,262 @@ type Config struct {
 	SourceFilesDir          string
 	OriginalFilesDir        string
 	OutputFilesDir          string
+	TrashFilesDir           string
+	TrashMaxAgeDays         int
+	PreviewFilesDir         string
 	YoutubeClientSecretFile string
-	Categories              []Category
-	Videos                  []Video
+	YoutubeDailyQuota       int
+	// PlayerCommand opens previews, the system player when empty.
+	PlayerCommand []string
+	// KeepRevisions is the number of previous edit outputs kept.
+	KeepRevisions int
+	// Categories are resolved from CategoryDefs at load time.
+	Categories   []Category        `json:"-"`
+	CategoryDefs []json.RawMessage `json:"Categories"`
+	// Presets are partial categories shared through Category.Presets.
+	Presets        map[string]json.RawMessage `json:",omitempty"`
+	EncodeProfiles []EncodeProfile
+	Videos         []Video
+	Tombstones     []Tombstone
+	// Duplicates are source files skipped as copies of a video, by name.
+	Duplicates map[string]Duplicate
+	// Sequences is the last Video.Seq given per category.
+	Sequences map[string]int
 }
 
 type Video struct {
 	Id                  int
 	SourceFileName      string
 	SourceFileCreatedAt int64
+	Size                int64
+	PartialHash         string
+	Hash                string
 	Extension           string
 	CategoryId          string
-	CreatedAt           int64
-	EditedAt            *int64
-	Url                 *string
-	UploadedAt          *int64
-	Range               *Range
+	// Seq counts the videos of the category, starting at 1.
+	Seq        int
+	Vars       map[string]string    `json:",omitempty"`
+	Dates      map[string]time.Time `json:",omitempty"`
+	CreatedAt  int64
+	EditedAt   *int64
+	Url        *string
+	RemoteId   *string
+	UploadedAt *int64
+	Range      *Range
+	// SuggestedRange is proposed by highlight detection.
+	SuggestedRange *Range
+	TrashedAt      *int64
+	PurgeMode      *string
+	Mute           bool
+	// OutputExtension is the container of the last edit.
+	OutputExtension string
+	Revisions       []Revision
+	// Revision is the number of the current revision.
+	Revision         int
+	UploadedRevision *int
+	// SubtitleFile is an attached .srt or .ass used instead of a sidecar file.
+	SubtitleFile *string
+	Overrides    Overrides
+}
+
+// Overrides replace category settings for a single video. Nil fields keep
+// the category value.
+type Overrides struct {
+	Text        *string  `json:",omitempty"`
+	Title       *string  `json:",omitempty"`
+	Description *string  `json:",omitempty"`
+	Tags        []string `json:",omitempty"`
+	// Privacy is public, unlisted or private.
+	Privacy       *string `json:",omitempty"`
+	Layout        *string `json:",omitempty"`
+	PaddingX      *int    `json:",omitempty"`
+	PaddingY      *int    `json:",omitempty"`
+	EncodeProfile *string `json:",omitempty"`
+}
+
+// Revision is one edit of a video with the settings that produced it.
+// OutputFile is empty once pruned.
+type Revision struct {
+	Number     int
+	CreatedAt  int64
+	Range      Range
+	Category   Category
+	Args       []string
+	OutputFile string
+	OutputHash string
+	// Fingerprint identifies the inputs and settings of the edit.
+	Fingerprint string
+	// SubtitleFile is the shifted subtitle burned into the output, empty
+	// without subtitles or once pruned.
+	SubtitleFile string
+	SubtitleHash string
+}
+
+// Duplicate is a source file found to be a copy of video Id. Size and
+// PartialHash tell whether the file changed since.
+type Duplicate struct {
+	Id          int
+	Size        int64
+	PartialHash string
+}
+
+// UnmarshalJSON reads the video id older configs stored alone, which is
+// never taken as unchanged.
+func (d *Duplicate) UnmarshalJSON(b []byte) error {
+	var id int
+	if json.Unmarshal(b, &id) == nil {
+		*d = Duplicate{Id: id}
+		return nil
+	}
+	type duplicate Duplicate
+	return json.Unmarshal(b, (*duplicate)(d))
+}
+
+// Tombstone is what remains of a purged video for auditing.
+type Tombstone struct {
+	Id             int
+	SourceFileName string
+	Size           int64
+	PartialHash    string
+	Hash           string
+	CategoryId     string
+	Url            *string
+	RemoteId       *string
+	UploadedAt     *int64
+	PurgedAt       int64
+	PurgeMode      string
 }
 
 type Category struct {
-	Id                   string
+	Id string
+	// Extends is the id of a base category whose fields are inherited.
+	Extends string `json:",omitempty"`
+	// Presets are names of Config.Presets applied over the base in order.
+	Presets              []string `json:",omitempty"`
 	DefaultRange         Range
 	EditOptions          EditOptions
 	OriginalFilePrefixes []string
-	YoutubeTags          []string
-	YoutubeCategory      string
-	YoutubeTitle         string
-	Text                 string
+	// Rules match source files, tried by descending Priority.
+	Rules           []MatchRule
+	Priority        int
+	YoutubeTags     []string
+	YoutubeCategory string
+	YoutubeTitle    string
+	// YoutubeDescription is a template like YoutubeTitle.
+	YoutubeDescription string
+	// YoutubePrivacy is public (default), unlisted or private.
+	YoutubePrivacy string
+	// YoutubeCaptions uploads the burned-in subtitle as a caption track too.
+	YoutubeCaptions        bool
+	YoutubeCaptionLanguage string
+	Text                   string
+	// Overlays replace Text when not empty.
+	Overlays []Overlay
+	// Intro and Outro are concatenated around the edited clip.
+	Intro *Bumper
+	Outro *Bumper
+	// EncodeProfile is the id of an EncodeProfile, DefaultEncodeProfile when empty.
+	EncodeProfile string
+	// Stages is the ordered filter pipeline. Empty means layout, text.
+	Stages []Stage
+}
+
+// MatchRule matches a source file when all of its non-empty conditions do.
+// Size and duration conditions probe the file. Named groups of Regex become
+// Video.Vars, and the groups in DateLayouts are parsed into Video.Dates.
+type MatchRule struct {
+	Prefix      string
+	Regex       string
+	DateLayouts map[string]string
+	Glob        string
+	Extensions  []string
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	MinDuration float64
+	MaxDuration float64
+}
+
+// Bumper is a clip or still image shown before or after the short.
+// Duration is required for images and trims clips when set.
+type Bumper struct {
+	File     string
+	Duration float64
 }
 
+// EncodeProfile is a set of encoder settings. Crf is used when Bitrate is
+// empty. Container is the output file extension.
+type EncodeProfile struct {
+	Id           string
+	VideoCodec   string
+	Crf          int
+	Bitrate      string
+	Preset       string
+	Profile      string
+	PixelFormat  string
+	MaxFrameRate int
+	Container    string
+	FastStart    bool
+	AudioCodec   string
+	AudioBitrate string
+	ExtraArgs    []string
+}
+
+const DefaultEncodeProfile = "shorts"
+
+// Overlay is a text drawn over the output frame. Anchor is top, center or
+// bottom and Align is left, center or right. Empty styles fall back to
+// EditOptions. Start and End are seconds into the clip before any speed
+// change.
+type Overlay struct {
+	Text           string
+	Anchor         string
+	Align          string
+	MarginX        int
+	MarginY        int
+	FontFile       string
+	FontSize       int
+	FontColor      string
+	BorderWidth    int
+	BorderColor    string
+	ShadowX        int
+	ShadowY        int
+	ShadowColor    string
+	Box            bool
+	BoxColor       string
+	BoxBorderWidth int
+	Start          *float64
+	End            *float64
+}
+
+// Stage is a named step of the filter pipeline. Params override the
+// filter options computed from EditOptions.
+type Stage struct {
+	Name   string
+	Params map[string]string `json:",omitempty"`
+}
+
+// Range is the part of the original used, in seconds. Speed is a playback
+// speed factor, 1 when zero, and Ramps change the speed from a keyframe on.
 type Range struct {
 	Start int
 	End   int
+	Speed float64     `json:",omitempty"`
+	Ramps []SpeedRamp `json:",omitempty"`
+}
+
+// SpeedRamp sets the speed from At seconds after the range start.
+type SpeedRamp struct {
+	At    float64
+	Speed float64
 }
 
 type EditOptions struct {
+	// Layout is pad (default), blur or focus.
+	Layout         string
+	BlurSigma      int
+	Focus          FocusRegion
 	OriginalWidth  int
 	OriginalHeight int
 	OutputHeight   int
@@ -64,6 +290,50 @@ type EditOptions struct {
 	PaddingX       int
 	PaddingY       int
 	PaddingColor   string
+	Images         []ImageOverlay
+	// SubtitleStyle is the ASS force_style of burned-in subtitles.
+	SubtitleStyle string
+	Audio         AudioOptions
+}
+
+// AudioOptions configures the audio of the output. Streams selects audio
+// streams of the original, all of them when empty. TargetLufs defaults to
+// -14 and MusicVolume to 0.3. Ducking lowers the music under game audio.
+type AudioOptions struct {
+	Streams      []int
+	Normalize    bool
+	TargetLufs   float64
+	Music        string
+	MusicVolume  float64
+	Ducking      bool
+	MusicFadeIn  float64
+	MusicFadeOut float64
+}
+
+// ImageOverlay is a picture such as a channel logo drawn over the output
+// frame. Width scales the picture keeping its aspect ratio, FadeIn and
+// FadeOut are seconds.
+type ImageOverlay struct {
+	File    string
+	Anchor  string
+	Align   string
+	MarginX int
+	MarginY int
+	Width   int
+	Opacity float64
+	FadeIn  float64
+	FadeOut float64
+}
+
+// FocusRegion is the part of the original picture stacked above the full
+// frame by the focus layout, scaled to the output width and Height.
+type FocusRegion struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// OutputHeight defaults to keeping the aspect ratio of the region.
+	OutputHeight int
 }
 
 var Data = Config{
@@ -71,8 +341,34 @@ var Data = Config{
 	SourceFilesDir:          "FILLHERE",
 	OriginalFilesDir:        "FILLHERE",
 	OutputFilesDir:          "FILLHERE",
+	TrashFilesDir:           "trash",
+	PreviewFilesDir:         "preview",
+	KeepRevisions:           3,
+	PlayerCommand:           []string{},
+	TrashMaxAgeDays:         30,
 	YoutubeClientSecretFile: "FILLHERE",
+	YoutubeDailyQuota:       10000,
 	Videos:                  []Video{},
+	EncodeProfiles: []EncodeProfile{
+		{
+			// https://support.google.com/youtube/answer/1722171
+			Id:           DefaultEncodeProfile,
+			VideoCodec:   "libx264",
+			Crf:          18,
+			Preset:       "medium",
+			Profile:      "high",
+			PixelFormat:  "yuv420p",
+			MaxFrameRate: 60,
+			Container:    ".mp4",
+			FastStart:    true,
+			AudioCodec:   "aac",
+			AudioBitrate: "384k",
+			ExtraArgs:    []string{"-bf", "2", "-flags", "+cgop"},
+		},
+	},
+	Tombstones: []Tombstone{},
+	Duplicates: map[string]Duplicate{},
+	Sequences:  map[string]int{},
 	Categories: []Category{
 		{
 			Id: CategoryLol,
@@ -81,11 +377,13 @@ var Data = Config{
 				End:   29,
 			},
 			EditOptions: EditOptions{
+				Layout:         "pad",
+				BlurSigma:      20,
 				OriginalWidth:  1920,
 				OriginalHeight: 1080,
 				OutputHeight:   1920,
 				OutputRatio:    1.7777777778,
-				FontFile:       "C\\:/Windows/Fonts/arial.ttf",
+				FontFile:       "C:/Windows/Fonts/arial.ttf",
 				FontColor:      "white",
 				FontSize:       48,
 				PaddingX:       0,
@@ -97,6 +395,7 @@ var Data = Config{
 			YoutubeCategory:      "20",
 			YoutubeTitle:         "{{.Id}} #leagueoflegends",
 			Text:                 "{{.Id}}",
+			Stages:               []Stage{{Name: "layout"}, {Name: "text"}},
 		},
 	},
 }
@@ -119,7 +418,70 @@ func Load() (err error) {
 		}
 		return err
 	}
-	return json.Unmarshal(b, &Data)
+	err = json.Unmarshal(b, &Data)
+	if err != nil {
+		return err
+	}
+	err = resolveCategories()
+	if err != nil {
+		return err
+	}
+	return validate()
+}
+
+func validate() error {
+	for _, c := range Data.Categories {
+		if c.YoutubeCaptions && c.YoutubeCaptionLanguage == "" {
+			return fmt.Errorf("category %s: YoutubeCaptionLanguage is required with YoutubeCaptions", c.Id)
+		}
+		templates := []string{c.YoutubeTitle, c.YoutubeDescription, c.Text}
+		for _, o := range c.Overlays {
+			templates = append(templates, o.Text)
+		}
+		for _, t := range templates {
+			_, err := utils.ParseTemplate(t)
+			if err != nil {
+				return fmt.Errorf("category %s: template: %w", c.Id, err)
+			}
+		}
+		for _, r := range c.Rules {
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return fmt.Errorf("category %s: rule: %w", c.Id, err)
+			}
+			for group := range r.DateLayouts {
+				if r.Regex == "" || re.SubexpIndex(group) == -1 {
+					return fmt.Errorf("category %s: rule: date layout of unknown group %s", c.Id, group)
+				}
+			}
+			_, err = path.Match(r.Glob, "")
+			if err != nil {
+				return fmt.Errorf("category %s: rule: %w", c.Id, err)
+			}
+		}
+		for _, img := range c.EditOptions.Images {
+			_, err := os.Stat(img.File)
+			if err != nil {
+				return fmt.Errorf("category %s: image: %w", c.Id, err)
+			}
+		}
+		for _, bp := range []*Bumper{c.Intro, c.Outro} {
+			if bp == nil {
+				continue
+			}
+			_, err := os.Stat(bp.File)
+			if err != nil {
+				return fmt.Errorf("category %s: bumper: %w", c.Id, err)
+			}
+		}
+		if c.EditOptions.Audio.Music != "" {
+			_, err := os.Stat(c.EditOptions.Audio.Music)
+			if err != nil {
+				return fmt.Errorf("category %s: music: %w", c.Id, err)
+			}
+		}
+	}
+	return nil
 }
 
 func Save() (err error) {
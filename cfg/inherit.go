@@ -0,0 +1,131 @@
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	Data.CategoryDefs = make([]json.RawMessage, len(Data.Categories))
+	for i, c := range Data.Categories {
+		b, err := json.Marshal(c)
+		if err != nil {
+			panic(err)
+		}
+		Data.CategoryDefs[i] = b
+	}
+}
+
+// resolveCategories builds Categories from CategoryDefs. A category is its
+// base category without the matching fields, then its presets, then its
+// own fields, merged field by field: objects merge recursively and
+// everything else is replaced.
+func resolveCategories() error {
+	defs := map[string]map[string]any{}
+	ids := make([]string, len(Data.CategoryDefs))
+	for i, raw := range Data.CategoryDefs {
+		def := map[string]any{}
+		err := json.Unmarshal(raw, &def)
+		if err != nil {
+			return fmt.Errorf("category %d: %w", i, err)
+		}
+		id, _ := def["Id"].(string)
+		if id == "" {
+			return fmt.Errorf("category %d: missing Id", i)
+		}
+		if _, ok := defs[id]; ok {
+			return fmt.Errorf("category %s: duplicate Id", id)
+		}
+		defs[id] = def
+		ids[i] = id
+	}
+	r := resolver{defs: defs, resolved: map[string]map[string]any{}}
+	cs := make([]Category, len(ids))
+	for i, id := range ids {
+		m, err := r.resolve(id, nil)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(b, &cs[i])
+		if err != nil {
+			return fmt.Errorf("category %s: %w", id, err)
+		}
+	}
+	Data.Categories = cs
+	return nil
+}
+
+// matchFields are not inherited from a base category, so a derived
+// category never takes over the files of its base.
+var matchFields = []string{"Rules", "OriginalFilePrefixes", "Priority"}
+
+type resolver struct {
+	defs     map[string]map[string]any
+	resolved map[string]map[string]any
+}
+
+func (r *resolver) resolve(id string, path []string) (map[string]any, error) {
+	if m, ok := r.resolved[id]; ok {
+		return m, nil
+	}
+	for _, p := range path {
+		if p == id {
+			return nil, fmt.Errorf("category %s: inheritance cycle %s", id, strings.Join(append(path, id), " -> "))
+		}
+	}
+	def := r.defs[id]
+	m := map[string]any{}
+	if base, _ := def["Extends"].(string); base != "" {
+		if _, ok := r.defs[base]; !ok {
+			return nil, fmt.Errorf("category %s: unknown base category %s", id, base)
+		}
+		b, err := r.resolve(base, append(path, id))
+		if err != nil {
+			return nil, err
+		}
+		merge(m, b)
+		for _, k := range matchFields {
+			delete(m, k)
+		}
+	}
+	presets, _ := def["Presets"].([]any)
+	for _, p := range presets {
+		name, _ := p.(string)
+		raw, ok := Data.Presets[name]
+		if !ok {
+			return nil, fmt.Errorf("category %s: unknown preset %s", id, name)
+		}
+		preset := map[string]any{}
+		err := json.Unmarshal(raw, &preset)
+		if err != nil {
+			return nil, fmt.Errorf("category %s: preset %s: %w", id, name, err)
+		}
+		merge(m, preset)
+	}
+	merge(m, def)
+	r.resolved[id] = m
+	return m, nil
+}
+
+// merge copies src into dst, merging nested objects instead of replacing them.
+func merge(dst, src map[string]any) {
+	for k, v := range src {
+		sm, ok := v.(map[string]any)
+		dm, ok2 := dst[k].(map[string]any)
+		if ok && ok2 {
+			merge(dm, sm)
+			continue
+		}
+		if ok {
+			cp := map[string]any{}
+			merge(cp, sm)
+			v = cp
+		}
+		dst[k] = v
+	}
+}
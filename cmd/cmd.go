@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
@@ -8,19 +11,36 @@ import (
 	"reflect"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/wirekang/p0418/cat"
 	"github.com/wirekang/p0418/cfg"
+	"github.com/wirekang/p0418/utils"
 	"github.com/wirekang/p0418/vdo"
+	"github.com/wirekang/p0418/ytb"
 )
 
 var commands = [](func() error){
 	editOlderUnedited,
 	editLatestEditedWithRange,
+	previewWithRange,
 	editUnuploaded,
+	forceEditUnuploaded,
+	suggestRanges,
+	acceptSuggestedRange,
+	revisions,
+	rollback,
+	editOverrides,
 	uploadEditedAndUnuploaded,
+	assignUnsorted,
+	recategorize,
+	rescan,
+	explain,
 	purgeOne,
 	purgeUploaded,
+	restore,
+	emptyTrash,
 	openOutputDir,
 	exit,
 }
@@ -36,27 +56,34 @@ func exit() error {
 }
 
 func editOlderUnedited() error {
-	video := sortVideos(func(v cfg.Video) int {
+	videos := sortVideos(func(v cfg.Video) int {
 		if v.EditedAt == nil {
 			return v.Id - 99999
 		}
 		return v.Id
-	})[0]
-	_ = video
-	return vdo.Edit(video)
+	})
+	if len(videos) == 0 {
+		return fmt.Errorf("no videos")
+	}
+	video := videos[0]
+	return vdo.Edit(video, false)
 }
 
 func editLatestEditedWithRange() error {
-	video := sortVideos(func(v cfg.Video) int {
+	videos := sortVideos(func(v cfg.Video) int {
 		if v.EditedAt == nil {
 			return math.MaxInt
 		}
 		return int(*v.EditedAt)
-	})[0]
+	})
+	if len(videos) == 0 {
+		return fmt.Errorf("no videos")
+	}
+	video := videos[0]
 	var start, end int
 	fmt.Print("start end: ")
 	fmt.Scanf("%d %d\n", &start, &end)
-	video.Range = &cfg.Range{Start: start, End: end}
+	video.Range = withStartEnd(video, start, end)
 	for i := range cfg.Data.Videos {
 		if cfg.Data.Videos[i].Id == video.Id {
 			cfg.Data.Videos[i].Range = video.Range
@@ -66,15 +93,75 @@ func editLatestEditedWithRange() error {
 	if err != nil {
 		return err
 	}
-	return vdo.Edit(video)
+	return vdo.Edit(video, false)
+}
+
+// withStartEnd returns the range of the video moved to start and end,
+// keeping its speed and ramps.
+func withStartEnd(v cfg.Video, start int, end int) *cfg.Range {
+	c, _ := cat.GetCategoryById(v.CategoryId)
+	r := c.DefaultRange
+	if v.Range != nil {
+		r = *v.Range
+	}
+	r.Start = start
+	r.End = end
+	return &r
+}
+
+func previewWithRange() error {
+	var id, start, end int
+	fmt.Print("id start end: ")
+	fmt.Scanf("%d %d %d\n", &id, &start, &end)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == id && cfg.Data.Videos[i].TrashedAt == nil {
+			v := &cfg.Data.Videos[i]
+			if v.UploadedAt != nil {
+				return fmt.Errorf("video %d is already uploaded", id)
+			}
+			r := withStartEnd(*v, start, end)
+			if v.Range == nil || !reflect.DeepEqual(*v.Range, *r) {
+				// the output no longer matches the range, edit it again before upload
+				v.Range = r
+				v.EditedAt = nil
+			}
+			err := cfg.Save()
+			if err != nil {
+				return err
+			}
+			return vdo.Preview(cfg.Data.Videos[i])
+		}
+	}
+	return fmt.Errorf("wrong id %d", id)
 }
 
 func editUnuploaded() error {
+	return editAllUnuploaded(false)
+}
+
+func forceEditUnuploaded() error {
+	return editAllUnuploaded(true)
+}
+
+func editAllUnuploaded(force bool) error {
+	for _, v := range cfg.Data.Videos {
+		if v.UploadedAt != nil || v.TrashedAt != nil {
+			continue
+		}
+		err := vdo.Edit(v, force)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func suggestRanges() error {
 	for _, v := range cfg.Data.Videos {
-		if v.UploadedAt != nil {
+		if v.EditedAt != nil || v.SuggestedRange != nil || v.TrashedAt != nil {
 			continue
 		}
-		err := vdo.Edit(v)
+		err := vdo.Suggest(v)
 		if err != nil {
 			return err
 		}
@@ -82,12 +169,197 @@ func editUnuploaded() error {
 	return nil
 }
 
+func acceptSuggestedRange() error {
+	videos := sortVideos(func(v cfg.Video) int {
+		if v.EditedAt == nil && v.SuggestedRange != nil {
+			return v.Id - 99999
+		}
+		return v.Id
+	})
+	if len(videos) == 0 {
+		return fmt.Errorf("no videos")
+	}
+	video := videos[0]
+	if video.EditedAt != nil || video.SuggestedRange == nil {
+		return fmt.Errorf("no unedited video with suggested range")
+	}
+	video.Range = video.SuggestedRange
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == video.Id {
+			cfg.Data.Videos[i].Range = video.Range
+		}
+	}
+	err := cfg.Save()
+	if err != nil {
+		return err
+	}
+	return vdo.Edit(video, false)
+}
+
+func revisions() error {
+	fmt.Print("id:")
+	var id int
+	fmt.Scanf("%d\n", &id)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == id {
+			vdo.PrintRevisions(cfg.Data.Videos[i])
+			return nil
+		}
+	}
+	return fmt.Errorf("wrong id %d", id)
+}
+
+func rollback() error {
+	var id, n int
+	fmt.Print("id revision: ")
+	fmt.Scanf("%d %d\n", &id, &n)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == id && cfg.Data.Videos[i].TrashedAt == nil {
+			return vdo.Rollback(cfg.Data.Videos[i], n)
+		}
+	}
+	return fmt.Errorf("wrong id %d", id)
+}
+
+// editOverrides sets one override of a video. An empty value clears it.
+func editOverrides() error {
+	var id int
+	fmt.Print("id:")
+	fmt.Scanf("%d\n", &id)
+	i := slices.IndexFunc(cfg.Data.Videos, func(v cfg.Video) bool { return v.Id == id && v.TrashedAt == nil })
+	if i == -1 {
+		return fmt.Errorf("wrong id %d", id)
+	}
+	o := &cfg.Data.Videos[i].Overrides
+	b, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	fmt.Print("field [text title description tags privacy layout paddingx paddingy profile] value: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	field, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+	value = strings.TrimSpace(value)
+	str := func() *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	num := func() (*int, error) {
+		if value == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(value)
+		return &n, err
+	}
+	switch field {
+	case "text", "title", "description":
+		_, err = utils.ParseTemplate(value)
+		if err != nil {
+			return err
+		}
+		switch field {
+		case "text":
+			o.Text = str()
+		case "title":
+			o.Title = str()
+		case "description":
+			o.Description = str()
+		}
+	case "tags":
+		o.Tags = nil
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				o.Tags = append(o.Tags, t)
+			}
+		}
+	case "privacy":
+		if value != "" && !slices.Contains([]string{"public", "unlisted", "private"}, value) {
+			return fmt.Errorf("wrong privacy %s", value)
+		}
+		o.Privacy = str()
+	case "layout":
+		if value != "" && !slices.Contains([]string{"pad", "blur", "focus"}, value) {
+			return fmt.Errorf("wrong layout %s", value)
+		}
+		o.Layout = str()
+	case "paddingx", "paddingy":
+		n, err := num()
+		if err != nil {
+			return err
+		}
+		if field == "paddingx" {
+			o.PaddingX = n
+		} else {
+			o.PaddingY = n
+		}
+	case "profile":
+		if value != "" {
+			_, err = cat.GetEncodeProfile(cfg.Category{EncodeProfile: value})
+			if err != nil {
+				return err
+			}
+		}
+		o.EncodeProfile = str()
+	default:
+		return fmt.Errorf("wrong field %s", field)
+	}
+	return cfg.Save()
+}
+
 func uploadEditedAndUnuploaded() error {
 	for _, v := range cfg.Data.Videos {
-		if v.UploadedAt != nil || v.EditedAt == nil {
+		if v.UploadedAt != nil || v.EditedAt == nil || v.TrashedAt != nil {
 			continue
 		}
 		err := vdo.Upload(v)
+		if errors.Is(err, ytb.ErrQuotaBudget) || errors.Is(err, ytb.ErrQuotaExceeded) {
+			fmt.Println("Deferred remaining uploads:", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assignUnsorted() error {
+	if len(vdo.Unsorted) == 0 {
+		return fmt.Errorf("no unsorted files")
+	}
+	for i, name := range vdo.Unsorted {
+		fmt.Printf("[%d] %s\n", i, name)
+	}
+	var i int
+	var categoryId string
+	fmt.Print("index category: ")
+	fmt.Scanf("%d %s\n", &i, &categoryId)
+	if i < 0 || i >= len(vdo.Unsorted) {
+		return fmt.Errorf("wrong index %d", i)
+	}
+	return vdo.Assign(vdo.Unsorted[i], categoryId)
+}
+
+func recategorize() error {
+	fmt.Print("ids... category: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("need ids and a category")
+	}
+	categoryId := fields[len(fields)-1]
+	for _, f := range fields[:len(fields)-1] {
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return fmt.Errorf("wrong id %s", f)
+		}
+		i := slices.IndexFunc(cfg.Data.Videos, func(v cfg.Video) bool { return v.Id == id && v.TrashedAt == nil })
+		if i == -1 {
+			return fmt.Errorf("wrong id %d", id)
+		}
+		err = vdo.Recategorize(cfg.Data.Videos[i], categoryId)
 		if err != nil {
 			return err
 		}
@@ -95,12 +367,64 @@ func uploadEditedAndUnuploaded() error {
 	return nil
 }
 
+func rescan() error {
+	return vdo.Rescan()
+}
+
+// explain prints the category as resolved from its base and presets.
+func explain() error {
+	var id string
+	fmt.Print("category: ")
+	fmt.Scanf("%s\n", &id)
+	c, err := cat.GetCategoryById(id)
+	if err != nil {
+		return err
+	}
+	chain := []string{c.Id}
+	for b := c; b.Extends != ""; {
+		b, err = cat.GetCategoryById(b.Extends)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, b.Id)
+	}
+	fmt.Println("extends:", strings.Join(chain, " <- "))
+	if len(c.Presets) > 0 {
+		fmt.Println("presets:", strings.Join(c.Presets, ", "))
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func scanPurgeMode() (vdo.PurgeMode, error) {
+	fmt.Print("mode [l]ocal [u]nlist [d]elete: ")
+	var m string
+	fmt.Scanf("%s\n", &m)
+	switch m {
+	case "l", "":
+		return vdo.PurgeLocal, nil
+	case "u":
+		return vdo.PurgeUnlist, nil
+	case "d":
+		return vdo.PurgeDelete, nil
+	}
+	return "", fmt.Errorf("wrong mode %s", m)
+}
+
 func purgeUploaded() error {
+	mode, err := scanPurgeMode()
+	if err != nil {
+		return err
+	}
 	for _, v := range cfg.Data.Videos {
-		if v.UploadedAt == nil {
+		if v.UploadedAt == nil || v.TrashedAt != nil {
 			continue
 		}
-		err := vdo.Purge(v)
+		err := vdo.Purge(v, mode)
 		if err != nil {
 			return err
 		}
@@ -109,20 +433,70 @@ func purgeUploaded() error {
 }
 
 func purgeOne() error {
+	fmt.Print("id:")
+	var id int
+	fmt.Scanf("%d\n", &id)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == id && cfg.Data.Videos[i].TrashedAt == nil {
+			mode, err := scanPurgeMode()
+			if err != nil {
+				return err
+			}
+			var confirm int
+			fmt.Print("type video id to confirm: ")
+			fmt.Scanf("%d\n", &confirm)
+			if confirm != id {
+				return fmt.Errorf("confirm failed")
+			}
+			return vdo.Purge(cfg.Data.Videos[i], mode)
+		}
+	}
+	return fmt.Errorf("wrong id %d", id)
+}
+
+func restore() error {
+	for _, v := range cfg.Data.Videos {
+		if v.TrashedAt != nil {
+			fmt.Println("trashed", v.Id, v.SourceFileName)
+		}
+	}
 	fmt.Print("id:")
 	var id int
 	fmt.Scanf("%d\n", &id)
 	for i := range cfg.Data.Videos {
 		if cfg.Data.Videos[i].Id == id {
-			return vdo.Purge(cfg.Data.Videos[i])
+			return vdo.Restore(cfg.Data.Videos[i])
 		}
 	}
 	return fmt.Errorf("wrong id %d", id)
 }
 
+func emptyTrash() error {
+	var confirm string
+	fmt.Print("type yes to confirm: ")
+	fmt.Scanf("%s\n", &confirm)
+	if confirm != "yes" {
+		return fmt.Errorf("confirm failed")
+	}
+	for _, v := range cfg.Data.Videos {
+		if v.TrashedAt == nil {
+			continue
+		}
+		err := vdo.EmptyTrash(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func sortVideos(f func(cfg.Video) int) []cfg.Video {
-	videos := make([]cfg.Video, len(cfg.Data.Videos))
-	copy(videos, cfg.Data.Videos)
+	videos := make([]cfg.Video, 0, len(cfg.Data.Videos))
+	for _, v := range cfg.Data.Videos {
+		if v.TrashedAt == nil {
+			videos = append(videos, v)
+		}
+	}
 	slices.SortFunc(videos, func(a, b cfg.Video) int {
 		return f(a) - f(b)
 	})
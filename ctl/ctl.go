@@ -13,6 +13,8 @@ import (
 	"github.com/rodaine/table"
 	"github.com/wirekang/p0418/cat"
 	"github.com/wirekang/p0418/cfg"
+	"github.com/wirekang/p0418/vdo"
+	"github.com/wirekang/p0418/ytb"
 )
 
 func Start(cmds []string, runCmd func(int) error) error {
@@ -20,6 +22,7 @@ func Start(cmds []string, runCmd func(int) error) error {
 	for {
 		fmt.Println("")
 		printVideos()
+		printQuota()
 		printCmd(cmds)
 		fmt.Print(">> ")
 		c, _ := r.ReadString('\n')
@@ -36,7 +39,7 @@ func Start(cmds []string, runCmd func(int) error) error {
 	}
 }
 
-var mappings = []string{"q", "w", "e", "r", "t", "a", "s", "d", "f", "g", "z", "x", "c", "v", "b"}
+var mappings = []string{"q", "w", "e", "r", "t", "a", "s", "d", "f", "g", "z", "x", "c", "v", "b", "y", "u", "i", "o", "p", "h", "j", "k", "l", "n", "m"}
 
 func printCmd(cmds []string) {
 	for i := range cmds {
@@ -53,17 +56,37 @@ func widthFunc(s string) int {
 func printVideos() {
 	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
 	columnFmt := color.New(color.FgYellow).SprintfFunc()
-	tbl := table.New("id", "cat.", "sourceFileName", "createdAt", "editedAt", "uploadedAt", "range")
+	tbl := table.New("id", "cat.", "sourceFileName", "createdAt", "editedAt", "uploadedAt", "range", "suggest")
 	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWidthFunc(widthFunc)
+	trashed := 0
 	for _, v := range cfg.Data.Videos {
+		if v.TrashedAt != nil {
+			trashed += 1
+			continue
+		}
 		c, _ := cat.GetCategoryById(v.CategoryId)
 		r := c.DefaultRange
 		if v.Range != nil {
 			r = *v.Range
 		}
-		tbl.AddRow(v.Id, v.CategoryId, v.SourceFileName, formatTime(&v.CreatedAt), formatTime(v.EditedAt), formatTime(v.UploadedAt), r)
+		tbl.AddRow(v.Id, v.CategoryId, v.SourceFileName, formatTime(&v.CreatedAt), formatTime(v.EditedAt), formatTime(v.UploadedAt), formatRange(r), formatSuggest(v.SuggestedRange))
 	}
 	tbl.Print()
+	if trashed > 0 {
+		fmt.Println("trash", trashed)
+	}
+	if len(vdo.Unsorted) > 0 {
+		fmt.Println("unsorted", len(vdo.Unsorted))
+	}
+}
+
+func printQuota() {
+	used, err := ytb.QuotaUsed()
+	if err != nil {
+		fmt.Println("quota", err)
+		return
+	}
+	fmt.Printf("quota %d/%d\n", used, ytb.QuotaBudget)
 }
 
 func formatTime(t *int64) string {
@@ -74,6 +97,24 @@ func formatTime(t *int64) string {
 	return tt.Format("0102:1504")
 }
 
+func formatRange(r cfg.Range) string {
+	s := fmt.Sprintf("%d-%d", r.Start, r.End)
+	if r.Speed != 0 && r.Speed != 1 {
+		s += fmt.Sprintf(" x%g", r.Speed)
+	}
+	for _, ramp := range r.Ramps {
+		s += fmt.Sprintf(" @%g:x%g", ramp.At, ramp.Speed)
+	}
+	return s
+}
+
+func formatSuggest(r *cfg.Range) string {
+	if r == nil {
+		return "-"
+	}
+	return formatRange(*r)
+}
+
 func formatBool(v bool) string {
 	if !v {
 		return color.New(color.FgRed).Sprint("☐")
@@ -2,12 +2,14 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/wirekang/p0418/cfg"
 	"github.com/wirekang/p0418/cmd"
 	"github.com/wirekang/p0418/ctl"
 	"github.com/wirekang/p0418/utils"
 	"github.com/wirekang/p0418/vdo"
+	"github.com/wirekang/p0418/ytb"
 )
 
 func m() error {
@@ -15,7 +17,9 @@ func m() error {
 	if err != nil {
 		return err
 	}
-	err = utils.MkdirAll(cfg.Data.OriginalFilesDir, cfg.Data.OutputFilesDir)
+	ytb.QuotaFile = filepath.Join(filepath.Dir(cfg.FileName), cfg.QuotaFileName)
+	ytb.QuotaBudget = cfg.Data.YoutubeDailyQuota
+	err = utils.MkdirAll(cfg.Data.OriginalFilesDir, cfg.Data.OutputFilesDir, cfg.Data.TrashFilesDir, cfg.Data.PreviewFilesDir)
 	if err != nil {
 		return err
 	}
@@ -23,6 +27,10 @@ func m() error {
 	if err != nil {
 		return err
 	}
+	err = vdo.ExpireTrash()
+	if err != nil {
+		return err
+	}
 	return ctl.Start(cmd.List(), cmd.Run)
 }
 
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func reflink(src string, dst string) (err error) {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(w.Fd()), int(r.Fd()))
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+func reflink(src string, dst string) error {
+	return errors.ErrUnsupported
+}
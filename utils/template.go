@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// TemplateFuncs are available in every template.
+//
+//	date "Jan 2" .CreatedAt   formats a unix time, *int64 or time.Time
+//	upper, lower, title       change case
+//	truncate 100 .            cuts to at most n characters
+//	join " " .Tags            joins a list
+//	default "x" .             replaces an empty value
+//	pad 3 .Seq                left-pads with zeros
+//	choice (list "a" "b")     picks a random item
+//	now                       the current time
+var TemplateFuncs = template.FuncMap{
+	"date":     date,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"title":    title,
+	"truncate": truncate,
+	"join":     join,
+	"default":  defaultValue,
+	"pad":      pad,
+	"choice":   choice,
+	"list":     func(items ...any) []any { return items },
+	"now":      time.Now,
+}
+
+var templates = map[string]*template.Template{}
+var templatesMu sync.Mutex
+
+// ParseTemplate parses t once and caches it for TemplateString.
+func ParseTemplate(t string) (*template.Template, error) {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	tt, ok := templates[t]
+	if ok {
+		return tt, nil
+	}
+	tt, err := template.New("t").Funcs(TemplateFuncs).Option("missingkey=zero").Parse(t)
+	if err != nil {
+		return nil, err
+	}
+	templates[t] = tt
+	return tt, nil
+}
+
+func TemplateString(t string, d any) (string, error) {
+	tt, err := ParseTemplate(t)
+	if err != nil {
+		return "", err
+	}
+	b := bytes.NewBufferString("")
+	err = tt.Execute(b, d)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func date(layout string, t any) (string, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case int64:
+		return time.Unix(t, 0).Format(layout), nil
+	case *int64:
+		if t == nil {
+			return "", nil
+		}
+		return time.Unix(*t, 0).Format(layout), nil
+	case int:
+		return time.Unix(int64(t), 0).Format(layout), nil
+	}
+	return "", fmt.Errorf("date: unsupported type %T", t)
+}
+
+func title(s string) string {
+	r := []rune(s)
+	for i := range r {
+		if i == 0 || unicode.IsSpace(r[i-1]) {
+			r[i] = unicode.ToUpper(r[i])
+		}
+	}
+	return string(r)
+}
+
+// truncate cuts s to n runes, YouTube counts title length in characters.
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n]))
+}
+
+func join(sep string, list any) (string, error) {
+	items, err := toList(list)
+	if err != nil {
+		return "", err
+	}
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = fmt.Sprint(item)
+	}
+	return strings.Join(s, sep), nil
+}
+
+func defaultValue(d any, v any) any {
+	if v == nil {
+		return d
+	}
+	rv := reflect.ValueOf(v)
+	if rv.IsZero() || (rv.Kind() == reflect.Pointer && rv.Elem().IsZero()) {
+		return d
+	}
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0 {
+		return d
+	}
+	return v
+}
+
+func pad(width int, v any) string {
+	return fmt.Sprintf("%0*v", width, v)
+}
+
+func choice(list any) (any, error) {
+	items, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+	return items[rand.Intn(len(items))], nil
+}
+
+func toList(list any) ([]any, error) {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("not a list: %T", list)
+	}
+	items := make([]any, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, nil
+}
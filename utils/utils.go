@@ -1,12 +1,22 @@
 package utils
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"text/template"
+	"os/exec"
+	"runtime"
+	"strconv"
 )
 
+// Copy streams src into a temporary file next to dst, syncs and verifies
+// it against the checksum of src, and only then renames it to dst, so a
+// crash never leaves a truncated dst behind.
 func Copy(src string, dst string) (err error) {
 	defer func() {
 		if err != nil {
@@ -18,11 +28,23 @@ func Copy(src string, dst string) (err error) {
 		return err
 	}
 	defer r.Close()
-	w, err := os.Create(dst)
+	tmp := dst + ".tmp"
+	w, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
-	_, err = w.ReadFrom(r)
+	defer func() {
+		if err != nil {
+			w.Close()
+			os.Remove(tmp)
+		}
+	}()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return err
+	}
+	err = w.Sync()
 	if err != nil {
 		return err
 	}
@@ -30,28 +52,172 @@ func Copy(src string, dst string) (err error) {
 	if err != nil {
 		return err
 	}
-	return nil
+	written, err := HashFile(tmp)
+	if err != nil {
+		return err
+	}
+	if written != hex.EncodeToString(h.Sum(nil)) {
+		return fmt.Errorf("checksum mismatch %s", tmp)
+	}
+	return os.Rename(tmp, dst)
 }
 
-func MkdirAll(dirs ...string) error {
-	for _, dir := range dirs {
-		err := os.MkdirAll(dir, os.ModeDir)
+// Clone makes dst a copy of src as cheaply as possible: a reflink when the
+// filesystem supports it, a hardlink when both are on the same filesystem,
+// and a verified Copy otherwise.
+func Clone(src string, dst string) (err error) {
+	defer func() {
 		if err != nil {
-			return err
+			err = fmt.Errorf("cloning file: %w", err)
 		}
+	}()
+	tmp := dst + ".tmp"
+	err = reflink(src, tmp)
+	if err == nil {
+		return os.Rename(tmp, dst)
 	}
-	return nil
+	os.Remove(tmp)
+	err = os.Link(src, tmp)
+	if err == nil {
+		return os.Rename(tmp, dst)
+	}
+	return Copy(src, dst)
+}
+
+// Move renames src to dst, copying across filesystems when renaming is not possible.
+func Move(src string, dst string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("moving file: %w", err)
+		}
+	}()
+	err = os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = Copy(src, dst)
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// HashFile returns the hex encoded sha256 of the file.
+func HashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func TemplateString(t string, d any) (string, error) {
-	tt, err := template.New("t").Parse(t)
+const partialHashSize = 1 << 20
+
+// PartialHash hashes only the head and the tail of the file, which is
+// enough to tell most different recordings of the same size apart.
+func PartialHash(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	i, err := f.Stat()
 	if err != nil {
 		return "", err
 	}
-	b := bytes.NewBufferString("")
-	err = tt.Execute(b, d)
+	h := sha256.New()
+	_, err = io.CopyN(h, f, min(i.Size(), partialHashSize))
 	if err != nil {
 		return "", err
 	}
-	return b.String(), nil
+	if i.Size() > partialHashSize*2 {
+		_, err = f.Seek(-partialHashSize, io.SeekEnd)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type ProbeInfo struct {
+	Width    int
+	Height   int
+	Duration float64
+	// AudioStreams is the number of audio streams.
+	AudioStreams int
+}
+
+// Probe reads the size of the first video stream, the number of audio
+// streams and the duration with ffprobe.
+func Probe(name string) (info ProbeInfo, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("probing %s: %w", name, err)
+		}
+	}()
+	b, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "stream=codec_type,width,height:format=duration", "-of", "json", name).Output()
+	if err != nil {
+		return info, err
+	}
+	out := struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int
+			Height    int
+		}
+		Format struct {
+			Duration string
+		}
+	}{}
+	err = json.Unmarshal(b, &out)
+	if err != nil {
+		return info, err
+	}
+	for _, s := range out.Streams {
+		switch {
+		case s.CodecType == "video" && info.Width == 0:
+			info.Width = s.Width
+			info.Height = s.Height
+		case s.CodecType == "audio":
+			info.AudioStreams += 1
+		}
+	}
+	info.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	return info, nil
+}
+
+// Open opens the file with the default application of the system.
+func Open(name string) error {
+	switch runtime.GOOS {
+	case "linux":
+		return exec.Command("xdg-open", name).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", name).Start()
+	case "darwin":
+		return exec.Command("open", name).Start()
+	}
+	return fmt.Errorf("cannot open %s on this platform", name)
+}
+
+func MkdirAll(dirs ...string) error {
+	for _, dir := range dirs {
+		err := os.MkdirAll(dir, os.ModeDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
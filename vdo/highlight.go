@@ -0,0 +1,148 @@
+package vdo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/wirekang/p0418/cat"
+	"github.com/wirekang/p0418/cfg"
+)
+
+// sceneWeight is how much scene changes count against loudness.
+const sceneWeight = 0.5
+
+func analysisGraph() cat.Graph {
+	return cat.Graph{Nodes: []cat.Node{
+		{
+			Inputs: []string{"0:a"},
+			Filters: []cat.Filter{
+				{Name: "ebur128", Options: []cat.Option{{Key: "metadata", Value: "1"}}},
+				{Name: "ametadata", Options: []cat.Option{{Key: "mode", Value: "print"}, {Key: "key", Value: "lavfi.r128.M"}, {Key: "file", Value: "-"}}},
+			},
+		},
+		{
+			Inputs: []string{"0:v"},
+			Filters: []cat.Filter{
+				{Name: "scale", Options: []cat.Option{{Key: "w", Value: "320"}, {Key: "h", Value: "-2"}}},
+				{Name: "scdet", Options: []cat.Option{{Key: "threshold", Value: "100"}}},
+				{Name: "metadata", Options: []cat.Option{{Key: "mode", Value: "print"}, {Key: "key", Value: "lavfi.scd.score"}, {Key: "file", Value: "-"}}},
+			},
+		},
+	}}
+}
+
+// analyze returns the momentary loudness and the summed scene change
+// score of every second of the original.
+func analyze(v cfg.Video) (loud []float64, scene []float64, err error) {
+	c := exec.Command("ffmpeg", "-hide_banner", "-nostats", "-i", originalFile(v), "-filter_complex", analysisGraph().String(), "-f", "null", "-")
+	stderr := bytes.Buffer{}
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s\n: %w", stderr.String(), err)
+	}
+	grow := func(s []float64, i int, init float64) []float64 {
+		for len(s) <= i {
+			s = append(s, init)
+		}
+		return s
+	}
+	sec := 0
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "frame:") {
+			for _, f := range strings.Fields(line) {
+				t, ok := strings.CutPrefix(f, "pts_time:")
+				if ok {
+					pt, _ := strconv.ParseFloat(t, 64)
+					sec = int(pt)
+				}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			continue
+		}
+		switch key {
+		case "lavfi.r128.M":
+			loud = grow(loud, sec, -70)
+			loud[sec] = max(loud[sec], n)
+		case "lavfi.scd.score":
+			scene = grow(scene, sec, 0)
+			scene[sec] += n
+		}
+	}
+	n := max(len(loud), len(scene))
+	return grow(loud, n-1, -70), grow(scene, n-1, 0), nil
+}
+
+// bestWindow scores every window of length seconds by its mean loudness
+// and its share of the scene changes, and returns the best start.
+func bestWindow(loud []float64, scene []float64, length int) int {
+	maxScene := 0.0
+	for _, s := range scene {
+		maxScene = max(maxScene, s)
+	}
+	score := func(i int) float64 {
+		l := min(max(loud[i]+70, 0), 70) / 70
+		if maxScene == 0 {
+			return l
+		}
+		return l + sceneWeight*scene[i]/maxScene
+	}
+	best, bestScore := 0, math.Inf(-1)
+	sum := 0.0
+	for i := range loud {
+		sum += score(i)
+		if i >= length {
+			sum -= score(i - length)
+		}
+		if i >= length-1 && sum > bestScore {
+			best, bestScore = i-length+1, sum
+		}
+	}
+	return best
+}
+
+// Suggest analyzes the original for loudness peaks and scene changes and
+// stores the best window of the category range length as SuggestedRange.
+func Suggest(v cfg.Video) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("suggesting range: %w", err)
+		}
+	}()
+	fmt.Println("Analyze", v.Id)
+	c, err := cat.GetCategoryById(v.CategoryId)
+	if err != nil {
+		return err
+	}
+	loud, scene, err := analyze(v)
+	if err != nil {
+		return err
+	}
+	length := c.DefaultRange.End - c.DefaultRange.Start
+	r := cfg.Range{Start: 0, End: min(length, len(loud))}
+	if len(loud) > length {
+		r.Start = bestWindow(loud, scene, length)
+		r.End = r.Start + length
+	}
+	fmt.Printf("Suggested %d-%d\n", r.Start, r.End)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == v.Id {
+			cfg.Data.Videos[i].SuggestedRange = &r
+		}
+	}
+	return cfg.Save()
+}
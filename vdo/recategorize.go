@@ -0,0 +1,108 @@
+package vdo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+
+	"github.com/wirekang/p0418/cat"
+	"github.com/wirekang/p0418/cfg"
+)
+
+// Recategorize moves the video into the category and invalidates its
+// edit, so the next edit renders with the new category.
+func Recategorize(v cfg.Video, categoryId string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("recategorizing video %d: %w", v.Id, err)
+		}
+	}()
+	if v.UploadedAt != nil {
+		return fmt.Errorf("already uploaded")
+	}
+	c, err := cat.GetCategoryById(categoryId)
+	if err != nil {
+		return err
+	}
+	m, err := cat.MatchCategory(c, v.SourceFileName, originalFile(v))
+	if errors.Is(err, cat.ErrUnknownCategory) {
+		// a manual move, the file name just has nothing to capture
+		if err != cat.ErrUnknownCategory {
+			fmt.Printf("Video %d: %s\n", v.Id, err)
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	err = setCategory(v, c, m)
+	if err != nil {
+		return err
+	}
+	return cfg.Save()
+}
+
+// Rescan re-evaluates the category rules for unedited videos, so they
+// follow category config changes.
+func Rescan() (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("rescanning videos: %w", err)
+		}
+	}()
+	for _, v := range cfg.Data.Videos {
+		if v.EditedAt != nil || v.UploadedAt != nil || v.TrashedAt != nil {
+			continue
+		}
+		c, m, err := cat.GetCategoryByName(v.SourceFileName, originalFile(v))
+		if errors.Is(err, cat.ErrUnknownCategory) {
+			fmt.Printf("No rule matches video %d, kept in %s: %s\n", v.Id, v.CategoryId, v.SourceFileName)
+			if err != cat.ErrUnknownCategory {
+				fmt.Println(" ", err)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if c.Id == v.CategoryId && maps.Equal(m.Vars, v.Vars) {
+			continue
+		}
+		err = setCategory(v, c, m)
+		if err != nil {
+			return err
+		}
+	}
+	return cfg.Save()
+}
+
+// setCategory updates the record with the captures of the new category
+// and removes the current output.
+func setCategory(v cfg.Video, c cfg.Category, m cat.Match) error {
+	err := os.Remove(outputFile(v))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for i := range cfg.Data.Videos {
+		cv := &cfg.Data.Videos[i]
+		if cv.Id != v.Id {
+			continue
+		}
+		if cv.CategoryId != c.Id {
+			fmt.Printf("Video %d: %s -> %s\n", v.Id, cv.CategoryId, c.Id)
+			if cfg.Data.Sequences == nil {
+				cfg.Data.Sequences = map[string]int{}
+			}
+			cfg.Data.Sequences[c.Id] += 1
+			cv.Seq = cfg.Data.Sequences[c.Id]
+		}
+		cv.CategoryId = c.Id
+		cv.Vars = m.Vars
+		cv.Dates = m.Dates
+		cv.EditedAt = nil
+		cv.Revision = 0
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package vdo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wirekang/p0418/cfg"
+	"github.com/wirekang/p0418/utils"
+)
+
+func revisionsDir() string {
+	return path.Join(cfg.Data.OutputFilesDir, "revisions")
+}
+
+func revisionFile(v cfg.Video, n int, ext string) string {
+	return path.Join(revisionsDir(), fmt.Sprintf("%d.%d%s", v.Id, n, ext))
+}
+
+func revisionFiles(v cfg.Video) []string {
+	files := []string{}
+	for _, r := range v.Revisions {
+		if r.OutputFile != "" {
+			files = append(files, r.OutputFile)
+		}
+		if r.SubtitleFile != "" {
+			files = append(files, r.SubtitleFile)
+		}
+	}
+	return files
+}
+
+// applyRevision makes the output and subtitle of rev the current ones of
+// the video.
+func applyRevision(v cfg.Video, rev cfg.Revision, isNew bool) error {
+	err := utils.Clone(rev.OutputFile, path.Join(cfg.Data.OutputFilesDir, fmt.Sprintf("%d%s", v.Id, path.Ext(rev.OutputFile))))
+	if err != nil {
+		return err
+	}
+	err = removeShiftedSubtitles(v, cfg.Data.OutputFilesDir)
+	if err != nil {
+		return err
+	}
+	if rev.SubtitleFile != "" {
+		err = utils.Clone(rev.SubtitleFile, path.Join(cfg.Data.OutputFilesDir, fmt.Sprintf("%d%s", v.Id, path.Ext(rev.SubtitleFile))))
+		if err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id != v.Id {
+			continue
+		}
+		cv := &cfg.Data.Videos[i]
+		if isNew {
+			cv.Revisions = append(cv.Revisions, rev)
+		} else {
+			r := rev.Range
+			cv.Range = &r
+		}
+		now := time.Now().Unix()
+		cv.EditedAt = &now
+		cv.Revision = rev.Number
+		cv.OutputExtension = path.Ext(rev.OutputFile)
+		err = pruneRevisions(cv)
+		if err != nil {
+			return err
+		}
+	}
+	return cfg.Save()
+}
+
+// pruneRevisions removes outputs of all but the last KeepRevisions
+// revisions with their subtitles. The current and the uploaded revisions
+// are always kept.
+func pruneRevisions(v *cfg.Video) error {
+	kept := 0
+	for i := len(v.Revisions) - 1; i >= 0; i-- {
+		r := &v.Revisions[i]
+		if r.OutputFile == "" {
+			continue
+		}
+		uploaded := v.UploadedRevision != nil && *v.UploadedRevision == r.Number
+		if kept < cfg.Data.KeepRevisions || r.Number == v.Revision || uploaded {
+			kept += 1
+			continue
+		}
+		for _, f := range []string{r.OutputFile, r.SubtitleFile} {
+			if f == "" {
+				continue
+			}
+			err := os.Remove(f)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		r.OutputFile = ""
+		r.SubtitleFile = ""
+	}
+	return nil
+}
+
+// Rollback makes a kept revision the current output again.
+func Rollback(v cfg.Video, n int) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("rolling back video: %w", err)
+		}
+	}()
+	fmt.Println("Rollback", v.Id, n)
+	if v.UploadedAt != nil {
+		return fmt.Errorf("video %d is already uploaded", v.Id)
+	}
+	i := slices.IndexFunc(v.Revisions, func(r cfg.Revision) bool { return r.Number == n })
+	if i == -1 {
+		return fmt.Errorf("wrong revision %d", n)
+	}
+	rev := v.Revisions[i]
+	if rev.OutputFile == "" {
+		return fmt.Errorf("output of revision %d is pruned", n)
+	}
+	return applyRevision(v, rev, false)
+}
+
+// PrintRevisions lists the revisions of the video with the ffmpeg args
+// that differ from the current revision.
+func PrintRevisions(v cfg.Video) {
+	var current []string
+	for _, r := range v.Revisions {
+		if r.Number == v.Revision {
+			current = r.Args
+		}
+	}
+	for _, r := range v.Revisions {
+		marks := []string{}
+		if r.Number == v.Revision {
+			marks = append(marks, "current")
+		}
+		if v.UploadedRevision != nil && *v.UploadedRevision == r.Number {
+			marks = append(marks, "uploaded")
+		}
+		if r.OutputFile == "" {
+			marks = append(marks, "pruned")
+		}
+		fmt.Printf("#%d %s range %d-%d hash %.12s %s\n", r.Number, time.Unix(r.CreatedAt, 0).Format("0102:1504"), r.Range.Start, r.Range.End, r.OutputHash, strings.Join(marks, ","))
+		if r.Number == v.Revision {
+			continue
+		}
+		for _, a := range r.Args {
+			if !slices.Contains(current, a) {
+				fmt.Println("  -", a)
+			}
+		}
+		for _, a := range current {
+			if !slices.Contains(r.Args, a) {
+				fmt.Println("  +", a)
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package vdo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wirekang/p0418/cat"
+	"github.com/wirekang/p0418/cfg"
+)
+
+var subtitleExtensions = []string{".srt", ".ass"}
+
+// subtitleFile returns the subtitle attached to the video or the sidecar
+// file next to its source file.
+func subtitleFile(v cfg.Video) (string, bool) {
+	if v.SubtitleFile != nil {
+		return *v.SubtitleFile, true
+	}
+	for _, f := range sidecarFiles(v) {
+		_, err := os.Stat(f)
+		if err == nil {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+func sidecarFiles(v cfg.Video) []string {
+	base := strings.TrimSuffix(v.SourceFileName, path.Ext(v.SourceFileName))
+	files := make([]string, len(subtitleExtensions))
+	for i, ext := range subtitleExtensions {
+		files[i] = path.Join(cfg.Data.SourceFilesDir, base+ext)
+	}
+	return files
+}
+
+// shiftedSubtitleFiles are the shifted subtitles in dir, the output dir
+// for edits and the preview dir for previews.
+func shiftedSubtitleFiles(v cfg.Video, dir string) []string {
+	files := make([]string, len(subtitleExtensions))
+	for i, ext := range subtitleExtensions {
+		files[i] = path.Join(dir, fmt.Sprintf("%d%s", v.Id, ext))
+	}
+	return files
+}
+
+// captionFile returns the subtitle burned into the current revision.
+func captionFile(v cfg.Video) (string, bool) {
+	for _, r := range v.Revisions {
+		if r.Number != v.Revision || r.SubtitleFile == "" {
+			continue
+		}
+		_, err := os.Stat(r.SubtitleFile)
+		return r.SubtitleFile, err == nil
+	}
+	return "", false
+}
+
+// shiftedSubtitle writes the subtitle of the video shifted to start at
+// the clip start and retimed by its speed into dir and returns its path.
+func shiftedSubtitle(v cfg.Video, r cfg.Range, dir string) (string, error) {
+	err := removeShiftedSubtitles(v, dir)
+	if err != nil {
+		return "", err
+	}
+	src, ok := subtitleFile(v)
+	if !ok {
+		return "", nil
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("reading subtitle: %w", err)
+	}
+	ext := strings.ToLower(path.Ext(src))
+	retime := func(d time.Duration) time.Duration {
+		d -= time.Duration(r.Start) * time.Second
+		if d <= 0 {
+			return d
+		}
+		return time.Duration(cat.OutputTime(r, d.Seconds()) * float64(time.Second))
+	}
+	shifted, err := shiftSubtitle(string(b), ext, retime)
+	if err != nil {
+		return "", fmt.Errorf("shifting subtitle %s: %w", src, err)
+	}
+	dst := path.Join(dir, fmt.Sprintf("%d%s", v.Id, ext))
+	return dst, os.WriteFile(dst, []byte(shifted), 0644)
+}
+
+func shiftSubtitle(s string, ext string, retime func(time.Duration) time.Duration) (string, error) {
+	switch ext {
+	case ".srt":
+		return shiftSrt(s, retime)
+	case ".ass":
+		return shiftAss(s, retime)
+	}
+	return "", fmt.Errorf("unknown subtitle format %s", ext)
+}
+
+// writeCaption writes the subtitle of the current revision delayed by the
+// intro into a temporary file, as the intro is joined after subtitles are
+// burned in.
+func writeCaption(v cfg.Video) (string, error) {
+	file, _ := captionFile(v)
+	var intro float64
+	for _, r := range v.Revisions {
+		if r.Number == v.Revision && r.Category.Intro != nil {
+			d, err := cat.BumperDuration(*r.Category.Intro)
+			if err != nil {
+				return "", err
+			}
+			intro = d
+		}
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	ext := path.Ext(file)
+	delay := time.Duration(intro * float64(time.Second))
+	shifted, err := shiftSubtitle(string(b), ext, func(d time.Duration) time.Duration { return d + delay })
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", fmt.Sprintf("caption-%d-*%s", v.Id, ext))
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(shifted)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+var srtTimingReg = regexp.MustCompile(`^(\d+):(\d\d):(\d\d)[,.](\d\d\d)\s*-->\s*(\d+):(\d\d):(\d\d)[,.](\d\d\d)(.*)$`)
+
+func shiftSrt(s string, retime func(time.Duration) time.Duration) (string, error) {
+	s = strings.ReplaceAll(strings.TrimPrefix(s, "\ufeff"), "\r\n", "\n")
+	out := []string{}
+	n := 0
+	for _, block := range strings.Split(strings.TrimSpace(s), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		i := slices.IndexFunc(lines, srtTimingReg.MatchString)
+		if i == -1 {
+			continue
+		}
+		m := srtTimingReg.FindStringSubmatch(lines[i])
+		start := retime(hmsm(m[1:5]))
+		end := retime(hmsm(m[5:9]))
+		if end <= 0 {
+			continue
+		}
+		n += 1
+		timing := fmt.Sprintf("%s --> %s%s", formatSrtTime(max(start, 0)), formatSrtTime(end), m[9])
+		out = append(out, strings.Join(append([]string{strconv.Itoa(n), timing}, lines[i+1:]...), "\n"))
+	}
+	return strings.Join(out, "\n\n") + "\n", nil
+}
+
+func hmsm(parts []string) time.Duration {
+	h, _ := strconv.Atoi(parts[0])
+	m, _ := strconv.Atoi(parts[1])
+	s, _ := strconv.Atoi(parts[2])
+	ms, _ := strconv.Atoi(parts[3])
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(ms)*time.Millisecond
+}
+
+func formatSrtTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+var assTimeReg = regexp.MustCompile(`^(\d+):(\d\d):(\d\d)\.(\d\d)$`)
+
+func parseAssTime(s string) (time.Duration, error) {
+	m := assTimeReg.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("wrong ass time %s", s)
+	}
+	return hmsm([]string{m[1], m[2], m[3], m[4] + "0"}), nil
+}
+
+func formatAssTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// shiftAss shifts the Start and End fields of Dialogue events, which are
+// the second and third fields in the standard event format.
+func shiftAss(s string, retime func(time.Duration) time.Duration) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "Dialogue:") {
+			out = append(out, l)
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(l, "Dialogue:"), ",", 4)
+		if len(fields) < 4 {
+			return "", fmt.Errorf("wrong dialogue %s", l)
+		}
+		start, err := parseAssTime(fields[1])
+		if err != nil {
+			return "", err
+		}
+		end, err := parseAssTime(fields[2])
+		if err != nil {
+			return "", err
+		}
+		start = retime(start)
+		end = retime(end)
+		if end <= 0 {
+			continue
+		}
+		fields[1] = formatAssTime(max(start, 0))
+		fields[2] = formatAssTime(end)
+		out = append(out, "Dialogue:"+strings.Join(fields, ","))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func removeShiftedSubtitles(v cfg.Video, dir string) error {
+	for _, f := range shiftedSubtitleFiles(v, dir) {
+		err := os.Remove(f)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package vdo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/wirekang/p0418/cfg"
+	"github.com/wirekang/p0418/utils"
+)
+
+const manifestFileName = "manifest.json"
+
+type trashManifest struct {
+	Id        int
+	TrashedAt int64
+	Files     []trashFile
+}
+
+type trashFile struct {
+	From string
+	To   string
+}
+
+func trashDir(id int) string {
+	return path.Join(cfg.Data.TrashFilesDir, strconv.Itoa(id))
+}
+
+func videoFiles(v cfg.Video) []string {
+	files := []string{
+		path.Join(cfg.Data.SourceFilesDir, v.SourceFileName),
+		path.Join(cfg.Data.OriginalFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension)),
+		outputFile(v),
+	}
+	files = append(files, sidecarFiles(v)...)
+	files = append(files, revisionFiles(v)...)
+	return append(files, shiftedSubtitleFiles(v, cfg.Data.OutputFilesDir)...)
+}
+
+func readManifest(id int) (trashManifest, error) {
+	m := trashManifest{}
+	b, err := os.ReadFile(path.Join(trashDir(id), manifestFileName))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+func trash(v cfg.Video, mode PurgeMode) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("trashing video: %w", err)
+		}
+	}()
+	now := time.Now().Unix()
+	dir := trashDir(v.Id)
+	err = utils.MkdirAll(dir)
+	if err != nil {
+		return err
+	}
+	m := trashManifest{Id: v.Id, TrashedAt: now}
+	for i, from := range videoFiles(v) {
+		to := path.Join(dir, fmt.Sprintf("%d-%s", i, path.Base(from)))
+		err = utils.Move(from, to)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, trashFile{From: from, To: to})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(dir, manifestFileName), b, 0644)
+	if err != nil {
+		return err
+	}
+	m2 := string(mode)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == v.Id {
+			cfg.Data.Videos[i].TrashedAt = &now
+			cfg.Data.Videos[i].PurgeMode = &m2
+		}
+	}
+	return cfg.Save()
+}
+
+// Restore moves the files of a trashed video back to where they were.
+func Restore(v cfg.Video) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("restoring video: %w", err)
+		}
+	}()
+	fmt.Println("Restore", v.Id)
+	if v.TrashedAt == nil {
+		return fmt.Errorf("video %d is not in trash", v.Id)
+	}
+	m, err := readManifest(v.Id)
+	if err != nil {
+		return err
+	}
+	for _, f := range m.Files {
+		_, err = os.Stat(f.From)
+		if err == nil {
+			return fmt.Errorf("%s already exists", f.From)
+		}
+	}
+	for _, f := range m.Files {
+		err = utils.Move(f.To, f.From)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.RemoveAll(trashDir(v.Id))
+	if err != nil {
+		return err
+	}
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == v.Id {
+			cfg.Data.Videos[i].TrashedAt = nil
+			cfg.Data.Videos[i].PurgeMode = nil
+		}
+	}
+	return cfg.Save()
+}
+
+// EmptyTrash deletes the files of a trashed video and replaces its record with a tombstone.
+func EmptyTrash(v cfg.Video) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("emptying trash: %w", err)
+		}
+	}()
+	fmt.Println("Empty trash", v.Id)
+	if v.TrashedAt == nil {
+		return fmt.Errorf("video %d is not in trash", v.Id)
+	}
+	err = os.RemoveAll(trashDir(v.Id))
+	if err != nil {
+		return err
+	}
+	mode := string(PurgeLocal)
+	if v.PurgeMode != nil {
+		mode = *v.PurgeMode
+	}
+	id, ok := remoteId(v)
+	var rid *string
+	if ok {
+		rid = &id
+	}
+	cfg.Data.Tombstones = append(cfg.Data.Tombstones, cfg.Tombstone{
+		Id:             v.Id,
+		SourceFileName: v.SourceFileName,
+		Size:           v.Size,
+		PartialHash:    v.PartialHash,
+		Hash:           v.Hash,
+		CategoryId:     v.CategoryId,
+		Url:            v.Url,
+		RemoteId:       rid,
+		UploadedAt:     v.UploadedAt,
+		PurgedAt:       *v.TrashedAt,
+		PurgeMode:      mode,
+	})
+	videos := make([]cfg.Video, 0)
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == v.Id {
+			continue
+		}
+		videos = append(videos, cfg.Data.Videos[i])
+	}
+	cfg.Data.Videos = videos
+	return cfg.Save()
+}
+
+// ExpireTrash empties trashed videos older than TrashMaxAgeDays.
+func ExpireTrash() error {
+	if cfg.Data.TrashMaxAgeDays <= 0 {
+		return nil
+	}
+	deadline := time.Now().AddDate(0, 0, -cfg.Data.TrashMaxAgeDays).Unix()
+	for _, v := range cfg.Data.Videos {
+		if v.TrashedAt == nil || *v.TrashedAt > deadline {
+			continue
+		}
+		err := EmptyTrash(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package vdo
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -8,6 +11,7 @@ import (
 	"os/exec"
 	"path"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/wirekang/p0418/cat"
@@ -24,6 +28,15 @@ func Load() (err error) {
 			err = fmt.Errorf("loading videos: %w", err)
 		}
 	}()
+	err = backfillPartialHashes()
+	if err != nil {
+		return err
+	}
+	err = backfillSeqs()
+	if err != nil {
+		return err
+	}
+	Unsorted = nil
 	nameId := makeNameId()
 	err = walkSoureFiles(func(i fs.FileInfo) error {
 		name := i.Name()
@@ -31,38 +44,243 @@ func Load() (err error) {
 		if ok {
 			return nil
 		}
-		c, err := cat.GetCategoryBySourceFileName(name)
+		dup, ok := cfg.Data.Duplicates[name]
+		if ok {
+			same, err := sameDuplicate(i, dup)
+			if err != nil {
+				return err
+			}
+			if same {
+				fmt.Printf("Duplicate of video %d skipped: %s\n", dup.Id, name)
+				return nil
+			}
+			delete(cfg.Data.Duplicates, name)
+		}
+		src := path.Join(cfg.Data.SourceFilesDir, name)
+		c, m, err := cat.GetCategoryBySourceFile(src)
 		if err != nil {
 			if errors.Is(err, cat.ErrUnknownCategory) {
+				if err != cat.ErrUnknownCategory {
+					fmt.Printf("Unsorted %s: %s\n", name, err)
+				}
+				Unsorted = append(Unsorted, name)
 				return nil
 			}
 			return err
 		}
-		id := cfg.Data.NextId
-		ext := path.Ext(name)
-		fmt.Printf("New video (%d): %s\n", id, name)
-		cfg.Data.Videos = append(cfg.Data.Videos, cfg.Video{
-			Id:                  id,
-			Extension:           ext,
-			SourceFileName:      name,
-			SourceFileCreatedAt: i.ModTime().Unix(),
-			CategoryId:          c.Id,
-			CreatedAt:           time.Now().Unix(),
-		})
-		cfg.Data.NextId += 1
-		err = cfg.Save()
+		return ingest(i, c, m)
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Unsorted lists source files no category rule matched.
+var Unsorted []string
+
+func ingest(i fs.FileInfo, c cfg.Category, m cat.Match) error {
+	name := i.Name()
+	src := path.Join(cfg.Data.SourceFilesDir, name)
+	partial, err := utils.PartialHash(src)
+	if err != nil {
+		return err
+	}
+	hash, dup, err := findDuplicate(src, i.Size(), partial)
+	if err != nil {
+		return err
+	}
+	if dup != nil {
+		fmt.Printf("Duplicate of video %d skipped: %s\n", *dup, name)
+		if cfg.Data.Duplicates == nil {
+			cfg.Data.Duplicates = map[string]cfg.Duplicate{}
+		}
+		cfg.Data.Duplicates[name] = cfg.Duplicate{Id: *dup, Size: i.Size(), PartialHash: partial}
+		return cfg.Save()
+	}
+	if hash == "" {
+		hash, err = utils.HashFile(src)
 		if err != nil {
 			return err
 		}
-		err = utils.Copy(path.Join(cfg.Data.SourceFilesDir, name), path.Join(cfg.Data.OriginalFilesDir, fmt.Sprintf("%d%s", id, ext)))
-		return err
+	}
+	id := cfg.Data.NextId
+	ext := path.Ext(name)
+	fmt.Printf("New video (%d): %s\n", id, name)
+	if cfg.Data.Sequences == nil {
+		cfg.Data.Sequences = map[string]int{}
+	}
+	cfg.Data.Sequences[c.Id] += 1
+	cfg.Data.Videos = append(cfg.Data.Videos, cfg.Video{
+		Id:                  id,
+		Extension:           ext,
+		SourceFileName:      name,
+		SourceFileCreatedAt: i.ModTime().Unix(),
+		Size:                i.Size(),
+		PartialHash:         partial,
+		Hash:                hash,
+		CategoryId:          c.Id,
+		Seq:                 cfg.Data.Sequences[c.Id],
+		Vars:                m.Vars,
+		Dates:               m.Dates,
+		CreatedAt:           time.Now().Unix(),
 	})
+	cfg.Data.NextId += 1
+	err = cfg.Save()
 	if err != nil {
 		return err
 	}
+	return utils.Clone(src, path.Join(cfg.Data.OriginalFilesDir, fmt.Sprintf("%d%s", id, ext)))
+}
+
+// Assign ingests an unsorted source file into the category.
+func Assign(name string, categoryId string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("assigning video: %w", err)
+		}
+	}()
+	i := slices.Index(Unsorted, name)
+	if i == -1 {
+		return fmt.Errorf("%s is not unsorted", name)
+	}
+	c, err := cat.GetCategoryById(categoryId)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path.Join(cfg.Data.SourceFilesDir, name))
+	if err != nil {
+		return err
+	}
+	err = ingest(info, c, cat.Match{})
+	if err != nil {
+		return err
+	}
+	Unsorted = slices.Delete(Unsorted, i, i+1)
 	return nil
 }
 
+func originalFile(v cfg.Video) string {
+	return path.Join(cfg.Data.OriginalFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension))
+}
+
+// backfillPartialHashes fills the prefilter fields of videos ingested
+// before content hashes were recorded.
+func backfillPartialHashes() error {
+	changed := false
+	for i := range cfg.Data.Videos {
+		v := &cfg.Data.Videos[i]
+		if v.PartialHash != "" {
+			continue
+		}
+		info, err := os.Stat(originalFile(*v))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		partial, err := utils.PartialHash(originalFile(*v))
+		if err != nil {
+			return err
+		}
+		v.Size = info.Size()
+		v.PartialHash = partial
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return cfg.Save()
+}
+
+// backfillSeqs numbers videos ingested before sequences were recorded,
+// after the videos already numbered in their category.
+func backfillSeqs() error {
+	if cfg.Data.Sequences == nil {
+		cfg.Data.Sequences = map[string]int{}
+	}
+	changed := false
+	for i := range cfg.Data.Videos {
+		v := &cfg.Data.Videos[i]
+		if v.Seq != 0 {
+			continue
+		}
+		cfg.Data.Sequences[v.CategoryId] += 1
+		v.Seq = cfg.Data.Sequences[v.CategoryId]
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return cfg.Save()
+}
+
+// sameDuplicate reports whether a source file skipped as a duplicate is
+// unchanged and its video or tombstone still exists.
+func sameDuplicate(i fs.FileInfo, dup cfg.Duplicate) (bool, error) {
+	if dup.Size != i.Size() {
+		return false, nil
+	}
+	exists := slices.ContainsFunc(cfg.Data.Videos, func(v cfg.Video) bool { return v.Id == dup.Id }) ||
+		slices.ContainsFunc(cfg.Data.Tombstones, func(t cfg.Tombstone) bool { return t.Id == dup.Id })
+	if !exists {
+		return false, nil
+	}
+	partial, err := utils.PartialHash(path.Join(cfg.Data.SourceFilesDir, i.Name()))
+	if err != nil {
+		return false, err
+	}
+	return partial == dup.PartialHash, nil
+}
+
+// findDuplicate looks for a video or tombstone with the same content.
+// Candidates are prefiltered by size and partial hash, so the full hash of
+// src is only computed when needed and then returned for reuse.
+func findDuplicate(src string, size int64, partial string) (hash string, id *int, err error) {
+	fullHash := func() (string, error) {
+		if hash == "" {
+			hash, err = utils.HashFile(src)
+		}
+		return hash, err
+	}
+	for i := range cfg.Data.Videos {
+		v := &cfg.Data.Videos[i]
+		if v.Size != size || v.PartialHash != partial {
+			continue
+		}
+		if v.Hash == "" {
+			v.Hash, err = utils.HashFile(originalFile(*v))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		h, err := fullHash()
+		if err != nil {
+			return "", nil, err
+		}
+		if h == v.Hash {
+			return h, &v.Id, nil
+		}
+	}
+	for _, t := range cfg.Data.Tombstones {
+		if t.Size != size || t.PartialHash != partial || t.Hash == "" {
+			continue
+		}
+		h, err := fullHash()
+		if err != nil {
+			return "", nil, err
+		}
+		if h == t.Hash {
+			return h, &t.Id, nil
+		}
+	}
+	return hash, nil, nil
+}
+
 func makeNameId() map[string]int {
 	nameId := make(map[string]int, len(cfg.Data.Videos))
 	for _, v := range cfg.Data.Videos {
@@ -87,6 +305,9 @@ func walkSoureFiles(f func(i fs.FileInfo) error) error {
 		if slices.Contains(ignoredFiles, i.Name()) {
 			continue
 		}
+		if slices.Contains(subtitleExtensions, strings.ToLower(path.Ext(i.Name()))) {
+			continue
+		}
 		err = f(i)
 		if err != nil {
 			return err
@@ -95,7 +316,27 @@ func walkSoureFiles(f func(i fs.FileInfo) error) error {
 	return nil
 }
 
-func ffmpegArgs(v cfg.Video) (args []string, err error) {
+// outputFile is the path of the last edit of the video.
+func outputFile(v cfg.Video) string {
+	ext := v.OutputExtension
+	if ext == "" {
+		ext = v.Extension
+	}
+	return path.Join(cfg.Data.OutputFilesDir, fmt.Sprintf("%d%s", v.Id, ext))
+}
+
+// edit is everything needed to render a video, before the output path.
+type edit struct {
+	category cfg.Category
+	profile  cfg.EncodeProfile
+	rng      cfg.Range
+	args     []string
+	ext      string
+	// subtitles is the shifted subtitle file, empty without subtitles.
+	subtitles string
+}
+
+func prepareEdit(v cfg.Video, preview bool) (e edit, err error) {
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("making ffmpeg args from video: %w", err)
@@ -104,20 +345,134 @@ func ffmpegArgs(v cfg.Video) (args []string, err error) {
 
 	c, err := cat.GetCategoryById(v.CategoryId)
 	if err != nil {
-		return nil, err
+		return e, err
+	}
+	c = cat.ApplyOverrides(c, v.Overrides)
+	p, err := cat.GetEncodeProfile(c)
+	if err != nil {
+		return e, err
+	}
+	r := c.DefaultRange
+	if v.Range != nil {
+		r = *v.Range
+	}
+	dir := cfg.Data.OutputFilesDir
+	if preview {
+		// keep the subtitle of the last edit for its caption upload
+		dir = cfg.Data.PreviewFilesDir
+	}
+	subtitles, err := shiftedSubtitle(v, r, dir)
+	if err != nil {
+		return e, err
 	}
-	cargs, err := cat.FfmpegArgs(c, v, v.Range)
+	args, err := cat.FfmpegArgs(c, cat.Input{File: originalFile(v), Range: &r, Subtitles: subtitles, Mute: v.Mute, Preview: preview, Data: v})
 	if err != nil {
-		return nil, err
+		return e, err
 	}
+	ext := p.Container
+	if ext == "" {
+		ext = v.Extension
+	}
+	return edit{category: c, profile: p, rng: r, args: args, ext: ext, subtitles: subtitles}, nil
+}
 
-	args = []string{"-i", path.Join(cfg.Data.OriginalFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension)), "-y", "-vcodec", "libx264", "-acodec", "copy"}
-	args = append(args, cargs...)
-	args = append(args, path.Join(cfg.Data.OutputFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension)))
-	return args, nil
+// fingerprint identifies everything that affects the output of an edit.
+func fingerprint(v cfg.Video, e edit) (string, error) {
+	if v.Hash == "" {
+		return "", fmt.Errorf("video %d has no content hash", v.Id)
+	}
+	files, err := inputStamps(e)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(struct {
+		OriginalHash string
+		Range        cfg.Range
+		Category     cfg.Category
+		Profile      cfg.EncodeProfile
+		Args         []string
+		Files        map[string]string
+	}{v.Hash, e.rng, e.category, e.profile, e.args, files})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// inputStamps identifies the contents of the files an edit reads besides
+// the original, so replacing a logo or fixing a subtitle is a new edit.
+// The subtitle is rewritten on every edit and is hashed, other files are
+// stamped by size and modification time.
+func inputStamps(e edit) (map[string]string, error) {
+	c := e.category
+	files := []string{c.EditOptions.FontFile, c.EditOptions.Audio.Music}
+	for _, o := range c.Overlays {
+		files = append(files, o.FontFile)
+	}
+	for _, img := range c.EditOptions.Images {
+		files = append(files, img.File)
+	}
+	for _, bp := range []*cfg.Bumper{c.Intro, c.Outro} {
+		if bp != nil {
+			files = append(files, bp.File)
+		}
+	}
+	stamps := map[string]string{}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if errors.Is(err, fs.ErrNotExist) {
+			stamps[f] = "missing"
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stamps[f] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+	}
+	if e.subtitles != "" {
+		hash, err := utils.HashFile(e.subtitles)
+		if err != nil {
+			return nil, err
+		}
+		stamps[e.subtitles] = hash
+	}
+	return stamps, nil
 }
 
-func Edit(v cfg.Video) (err error) {
+// ensureHash computes the content hash of videos ingested before hashes were recorded.
+func ensureHash(v *cfg.Video) error {
+	if v.Hash != "" {
+		return nil
+	}
+	hash, err := utils.HashFile(originalFile(*v))
+	if err != nil {
+		return err
+	}
+	v.Hash = hash
+	for i := range cfg.Data.Videos {
+		if cfg.Data.Videos[i].Id == v.Id {
+			cfg.Data.Videos[i].Hash = hash
+		}
+	}
+	return cfg.Save()
+}
+
+func ffmpeg(args []string, output string) error {
+	args = append(slices.Clone(args), "-y", output)
+	b, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n: %w", string(b), err)
+	}
+	return nil
+}
+
+// Edit renders the video unless a kept revision has the same fingerprint,
+// or force is set.
+func Edit(v cfg.Video, force bool) (err error) {
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("editting video: %w", err)
@@ -125,28 +480,103 @@ func Edit(v cfg.Video) (err error) {
 	}()
 	start := time.Now()
 	fmt.Println("Edit", v.Id)
-	args, err := ffmpegArgs(v)
+	err = ensureHash(&v)
 	if err != nil {
 		return err
 	}
-	c := exec.Command("ffmpeg", args...)
-	b, err := c.CombinedOutput()
+	e, err := prepareEdit(v, false)
 	if err != nil {
-		return fmt.Errorf("%s\n: %w", string(b), err)
+		return err
+	}
+	fp, err := fingerprint(v, e)
+	if err != nil {
+		return err
+	}
+	if !force {
+		for _, r := range v.Revisions {
+			if r.Fingerprint != fp || r.OutputFile == "" {
+				continue
+			}
+			_, err = os.Stat(r.OutputFile)
+			if err != nil {
+				continue
+			}
+			if r.Number == v.Revision && v.EditedAt != nil {
+				fmt.Println("Up to date, revision", r.Number)
+				return nil
+			}
+			fmt.Println("Reuse revision", r.Number)
+			return applyRevision(v, r, false)
+		}
+	}
+	err = utils.MkdirAll(revisionsDir())
+	if err != nil {
+		return err
+	}
+	n := 1
+	if len(v.Revisions) > 0 {
+		n = v.Revisions[len(v.Revisions)-1].Number + 1
+	}
+	output := revisionFile(v, n, e.ext)
+	err = ffmpeg(e.args, output)
+	if err != nil {
+		return err
 	}
 	duration := time.Since(start)
 	fmt.Println("Success", duration)
-	for i := range cfg.Data.Videos {
-		if cfg.Data.Videos[i].Id == v.Id {
-			now := time.Now().Unix()
-			cfg.Data.Videos[i].EditedAt = &now
+	hash, err := utils.HashFile(output)
+	if err != nil {
+		return err
+	}
+	rev := cfg.Revision{
+		Number:      n,
+		CreatedAt:   time.Now().Unix(),
+		Range:       e.rng,
+		Category:    e.category,
+		Args:        e.args,
+		OutputFile:  output,
+		OutputHash:  hash,
+		Fingerprint: fp,
+	}
+	if e.subtitles != "" {
+		rev.SubtitleFile = revisionFile(v, n, path.Ext(e.subtitles))
+		err = utils.Copy(e.subtitles, rev.SubtitleFile)
+		if err != nil {
+			return err
+		}
+		rev.SubtitleHash, err = utils.HashFile(rev.SubtitleFile)
+		if err != nil {
+			return err
 		}
 	}
-	err = cfg.Save()
+	return applyRevision(v, rev, true)
+}
+
+// Preview renders the edit at low resolution into the preview dir and
+// opens it in the player.
+func Preview(v cfg.Video) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("previewing video: %w", err)
+		}
+	}()
+	start := time.Now()
+	fmt.Println("Preview", v.Id)
+	e, err := prepareEdit(v, true)
 	if err != nil {
 		return err
 	}
-	return nil
+	output := path.Join(cfg.Data.PreviewFilesDir, fmt.Sprintf("%d%s", v.Id, e.ext))
+	err = ffmpeg(e.args, output)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Success", time.Since(start))
+	if len(cfg.Data.PlayerCommand) == 0 {
+		return utils.Open(output)
+	}
+	args := append(slices.Clone(cfg.Data.PlayerCommand[1:]), output)
+	return exec.Command(cfg.Data.PlayerCommand[0], args...).Start()
 }
 
 func Upload(v cfg.Video) (err error) {
@@ -156,55 +586,125 @@ func Upload(v cfg.Video) (err error) {
 			err = fmt.Errorf("uploading video: %w", err)
 		}
 	}()
+	c, err := cat.GetCategoryById(v.CategoryId)
+	if err != nil {
+		return err
+	}
+	c = cat.ApplyOverrides(c, v.Overrides)
+	_, hasCaption := captionFile(v)
+	hasCaption = hasCaption && c.YoutubeCaptions
+	cost := ytb.CostVideosInsert
+	if hasCaption {
+		cost += ytb.CostCaptionsInsert
+	}
+	err = ytb.CanSpend(cost)
+	if err != nil {
+		return err
+	}
 	var confirm int
 	fmt.Print("type video id to confirm: ")
 	fmt.Scanf("%d\n", &confirm)
 	if confirm != v.Id {
 		return fmt.Errorf("confirm failed")
 	}
-	c, err := cat.GetCategoryById(v.CategoryId)
+	title, err := cat.RenderTemplate(c, c.YoutubeTitle, v)
 	if err != nil {
 		return err
 	}
-	title, err := utils.TemplateString(c.YoutubeTitle, v)
+	description, err := cat.RenderTemplate(c, c.YoutubeDescription, v)
 	if err != nil {
 		return err
 	}
+	privacy := c.YoutubePrivacy
+	if privacy == "" {
+		privacy = "public"
+	}
 	url, err := ytb.Upload(ytb.UploadProps{
 		Title:            title,
-		Description:      "",
+		Description:      description,
 		Category:         c.YoutubeCategory,
 		Tags:             c.YoutubeTags,
-		Public:           true,
-		File:             path.Join(cfg.Data.OutputFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension)),
+		Privacy:          privacy,
+		File:             outputFile(v),
 		ClientSecretFile: cfg.Data.YoutubeClientSecretFile,
 	})
 	if err != nil {
 		return err
 	}
 	fmt.Println("Success")
+	remoteId := path.Base(url)
 	for i := range cfg.Data.Videos {
 		if cfg.Data.Videos[i].Id == v.Id {
 			now := time.Now().Unix()
 			cfg.Data.Videos[i].UploadedAt = &now
+			rev := cfg.Data.Videos[i].Revision
+			cfg.Data.Videos[i].UploadedRevision = &rev
 			cfg.Data.Videos[i].Url = &url
+			cfg.Data.Videos[i].RemoteId = &remoteId
 		}
 	}
-	return cfg.Save()
+	err = cfg.Save()
+	if err != nil {
+		return err
+	}
+	if !hasCaption {
+		return nil
+	}
+	caption, err := writeCaption(v)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(caption)
+	fmt.Println("Upload caption", caption)
+	return ytb.UploadCaption(cfg.Data.YoutubeClientSecretFile, remoteId, c.YoutubeCaptionLanguage, caption)
 }
 
-func Purge(v cfg.Video) error {
-	fmt.Println("Purge", v.Id)
-	videos := make([]cfg.Video, 0)
-	for i := range cfg.Data.Videos {
-		if cfg.Data.Videos[i].Id == v.Id {
-			os.Remove(path.Join(cfg.Data.SourceFilesDir, v.SourceFileName))
-			os.Remove(path.Join(cfg.Data.OriginalFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension)))
-			os.Remove(path.Join(cfg.Data.OutputFilesDir, fmt.Sprintf("%d%s", v.Id, v.Extension)))
-			continue
+type PurgeMode string
+
+const (
+	PurgeLocal  PurgeMode = "local"
+	PurgeUnlist PurgeMode = "unlist"
+	PurgeDelete PurgeMode = "delete"
+)
+
+func remoteId(v cfg.Video) (string, bool) {
+	if v.RemoteId != nil {
+		return *v.RemoteId, true
+	}
+	if v.Url != nil {
+		return path.Base(*v.Url), true
+	}
+	return "", false
+}
+
+func purgeRemote(v cfg.Video, mode PurgeMode) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("purging remote video: %w", err)
 		}
-		videos = append(videos, cfg.Data.Videos[i])
+	}()
+	id, ok := remoteId(v)
+	if !ok || v.UploadedAt == nil {
+		return nil
 	}
-	cfg.Data.Videos = videos
-	return cfg.Save()
+	switch mode {
+	case PurgeLocal:
+		return nil
+	case PurgeUnlist:
+		fmt.Println("Unlist", id)
+		return ytb.Unlist(cfg.Data.YoutubeClientSecretFile, id)
+	case PurgeDelete:
+		fmt.Println("Delete", id)
+		return ytb.Delete(cfg.Data.YoutubeClientSecretFile, id)
+	}
+	return fmt.Errorf("unknown purge mode %s", mode)
+}
+
+func Purge(v cfg.Video, mode PurgeMode) error {
+	fmt.Println("Purge", v.Id, mode)
+	err := purgeRemote(v, mode)
+	if err != nil {
+		return err
+	}
+	return trash(v, mode)
 }
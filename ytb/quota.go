@@ -0,0 +1,124 @@
+package ytb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+	_ "time/tzdata"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Quota costs of the YouTube Data API methods used by this package.
+// https://developers.google.com/youtube/v3/determine_quota_cost
+const (
+	CostVideosList     = 1
+	CostVideosInsert   = 1600
+	CostVideosUpdate   = 50
+	CostVideosDelete   = 50
+	CostCaptionsInsert = 400
+)
+
+// QuotaFile is the ledger of quota units spent per Pacific-time day.
+var QuotaFile = "quota.json"
+
+// QuotaBudget is the number of units we allow ourselves to spend per day.
+var QuotaBudget = 10000
+
+var ErrQuotaBudget = fmt.Errorf("youtube quota budget would be exceeded, retry tomorrow")
+var ErrQuotaExceeded = fmt.Errorf("youtube quota exceeded, retry tomorrow")
+
+// quota resets at midnight Pacific Time.
+var pacific, _ = time.LoadLocation("America/Los_Angeles")
+
+func quotaDay() string {
+	return time.Now().In(pacific).Format("2006-01-02")
+}
+
+func loadLedger() (map[string]int, error) {
+	ledger := map[string]int{}
+	b, err := os.ReadFile(QuotaFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ledger, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &ledger)
+	if err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+func saveLedger(ledger map[string]int) error {
+	b, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(QuotaFile, b, 0644)
+}
+
+// QuotaUsed returns the units spent today.
+func QuotaUsed() (int, error) {
+	ledger, err := loadLedger()
+	if err != nil {
+		return 0, fmt.Errorf("loading quota ledger: %w", err)
+	}
+	return ledger[quotaDay()], nil
+}
+
+// CanSpend reports ErrQuotaBudget if spending cost units today would exceed QuotaBudget.
+func CanSpend(cost int) error {
+	used, err := QuotaUsed()
+	if err != nil {
+		return err
+	}
+	if used+cost > QuotaBudget {
+		return fmt.Errorf("%w: used %d, need %d, budget %d", ErrQuotaBudget, used, cost, QuotaBudget)
+	}
+	return nil
+}
+
+// spend checks the budget and records cost units before an API call.
+// The API charges failed calls as well, so units are recorded up front.
+func spend(cost int) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("spending quota: %w", err)
+		}
+	}()
+	ledger, err := loadLedger()
+	if err != nil {
+		return err
+	}
+	day := quotaDay()
+	if ledger[day]+cost > QuotaBudget {
+		return fmt.Errorf("%w: used %d, need %d, budget %d", ErrQuotaBudget, ledger[day], cost, QuotaBudget)
+	}
+	ledger[day] += cost
+	return saveLedger(ledger)
+}
+
+// apiError translates quotaExceeded responses into ErrQuotaExceeded and
+// marks today as exhausted so further calls are refused locally.
+func apiError(err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return err
+	}
+	for _, e := range gerr.Errors {
+		if e.Reason == "quotaExceeded" || e.Reason == "dailyLimitExceeded" {
+			ledger, lerr := loadLedger()
+			if lerr == nil {
+				ledger[quotaDay()] = max(ledger[quotaDay()], QuotaBudget)
+				_ = saveLedger(ledger)
+			}
+			return fmt.Errorf("%w: %s", ErrQuotaExceeded, e.Message)
+		}
+	}
+	return err
+}
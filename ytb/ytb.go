@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
 
@@ -67,7 +68,7 @@ func getClient(secretFile string, scope string) *http.Client {
 	// Use the following redirect URI if launchWebServer=false in oauth2.go
 	// config.RedirectURL = "urn:ietf:wg:oauth:2.0:oob"
 
-	cacheFile, err := tokenCacheFile()
+	cacheFile, err := tokenCacheFile(scope)
 	if err != nil {
 		log.Fatalf("Unable to get path to cached credential file. %v", err)
 	}
@@ -174,17 +175,21 @@ func getTokenFromWeb(config *oauth2.Config, authURL string) (*oauth2.Token, erro
 	return exchangeToken(config, code)
 }
 
-// tokenCacheFile generates credential file path/filename.
+// tokenCacheFile generates credential file path/filename for the scope.
 // It returns the generated credential path/filename.
-func tokenCacheFile() (string, error) {
+func tokenCacheFile(scope string) (string, error) {
 	usr, err := user.Current()
 	if err != nil {
 		return "", err
 	}
 	tokenCacheDir := filepath.Join(usr.HomeDir, ".credentials")
 	os.MkdirAll(tokenCacheDir, 0700)
+	name := "youtube-go.json"
+	if scope != youtube.YoutubeUploadScope {
+		name = "youtube-go-" + path.Base(scope) + ".json"
+	}
 	return filepath.Join(tokenCacheDir,
-		url.QueryEscape("youtube-go.json")), err
+		url.QueryEscape(name)), err
 }
 
 // tokenFromFile retrieves a Token from a given file path.
@@ -214,11 +219,12 @@ func saveToken(file string, token *oauth2.Token) {
 }
 
 type UploadProps struct {
-	Title            string
-	Description      string
-	Category         string
-	Tags             []string
-	Public           bool
+	Title       string
+	Description string
+	Category    string
+	Tags        []string
+	// Privacy is public, unlisted or private (default).
+	Privacy          string
 	File             string
 	ClientSecretFile string
 }
@@ -229,9 +235,9 @@ func Upload(props UploadProps) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	privacyStatus := "private"
-	if props.Public {
-		privacyStatus = "public"
+	privacyStatus := props.Privacy
+	if privacyStatus == "" {
+		privacyStatus = "private"
 	}
 	v := &youtube.Video{
 		Snippet: &youtube.VideoSnippet{
@@ -247,11 +253,103 @@ func Upload(props UploadProps) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	err = spend(CostVideosInsert)
+	if err != nil {
+		return "", err
+	}
 	call := service.Videos.Insert([]string{"snippet", "status"}, v)
 	response, err := call.Media(file).Do()
 	if err != nil {
-		return "", err
+		return "", apiError(err)
 	}
 	url := "https://www.youtube.com/shorts/" + response.Id
 	return url, nil
 }
+
+// Unlist changes the privacy status of an uploaded video to unlisted.
+func Unlist(secretFile string, id string) error {
+	client := getClient(secretFile, youtube.YoutubeScope)
+	service, err := youtube.New(client)
+	if err != nil {
+		return err
+	}
+	err = CanSpend(CostVideosList + CostVideosUpdate)
+	if err != nil {
+		return err
+	}
+	err = spend(CostVideosList)
+	if err != nil {
+		return err
+	}
+	// updating a part replaces all of it, so keep the other status fields
+	res, err := service.Videos.List([]string{"status"}).Id(id).Do()
+	if err != nil {
+		return apiError(err)
+	}
+	if len(res.Items) == 0 {
+		return fmt.Errorf("video %s not found", id)
+	}
+	status := res.Items[0].Status
+	status.PrivacyStatus = "unlisted"
+	err = spend(CostVideosUpdate)
+	if err != nil {
+		return err
+	}
+	v := &youtube.Video{
+		Id:     id,
+		Status: status,
+	}
+	_, err = service.Videos.Update([]string{"status"}, v).Do()
+	if err != nil {
+		return apiError(err)
+	}
+	return nil
+}
+
+// Delete removes an uploaded video from YouTube.
+func Delete(secretFile string, id string) error {
+	client := getClient(secretFile, youtube.YoutubeScope)
+	service, err := youtube.New(client)
+	if err != nil {
+		return err
+	}
+	err = spend(CostVideosDelete)
+	if err != nil {
+		return err
+	}
+	err = service.Videos.Delete(id).Do()
+	if err != nil {
+		return apiError(err)
+	}
+	return nil
+}
+
+// UploadCaption adds a caption track to an uploaded video.
+func UploadCaption(secretFile string, id string, language string, file string) error {
+	client := getClient(secretFile, youtube.YoutubeForceSslScope)
+	service, err := youtube.New(client)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = spend(CostCaptionsInsert)
+	if err != nil {
+		return err
+	}
+	c := &youtube.Caption{
+		Snippet: &youtube.CaptionSnippet{
+			VideoId:  id,
+			Language: language,
+			Name:     "",
+		},
+	}
+	_, err = service.Captions.Insert([]string{"snippet"}, c).Media(f).Do()
+	if err != nil {
+		return apiError(err)
+	}
+	return nil
+}